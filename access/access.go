@@ -0,0 +1,339 @@
+package access
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bvantagelimited/freeradius_exporter/secret"
+)
+
+// Reasons reported for denied requests.
+const (
+	ReasonIPDenied     = "ip_denied"
+	ReasonBadToken     = "bad_token"
+	ReasonIPNotAllowed = "ip_not_allowed"
+)
+
+// Token is a named auth token accepted in the X-Auth-Token header.
+type Token struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+	File  string `json:"file,omitempty"`
+}
+
+// Rule is the access rule of a path. A path ending with "/" matches every path below it.
+type Rule struct {
+	Path string `json:"path"`
+	// Tokens lists the names of the tokens accepted for the path, empty means all tokens.
+	Tokens []string `json:"tokens,omitempty"`
+	Allow  []string `json:"allow,omitempty"`
+	Deny   []string `json:"deny,omitempty"`
+}
+
+// Config describes an access policy.
+type Config struct {
+	Tokens         []Token  `json:"tokens"`
+	TrustedProxies []string `json:"trusted_proxies"`
+	// Default is used for protected paths without a matching rule.
+	Default Rule   `json:"default"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Policy decides which requests may access the protected paths.
+type Policy struct {
+	// tokens holds the values of all tokens, those of files are updated by Watch
+	tokens         map[string]string
+	tokenFiles     map[string]*secret.File
+	tokenMutex     sync.RWMutex
+	trustedProxies []*net.IPNet
+	defaultRule    *rule
+	rules          map[string]*rule
+	auditor        *Auditor
+}
+
+type rule struct {
+	tokens []string
+	allow  []*net.IPNet
+	deny   []*net.IPNet
+}
+
+// LoadConfig reads a JSON access policy file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed parsing access config '%v': %w", path, err)
+	}
+	return cfg, nil
+}
+
+// NewPolicy creates a Policy.
+func NewPolicy(cfg Config) (*Policy, error) {
+	p := &Policy{
+		tokens:     make(map[string]string),
+		tokenFiles: make(map[string]*secret.File),
+		rules:      make(map[string]*rule),
+	}
+
+	// all the problems of the config are returned at once
+	var errs []error
+	for _, t := range cfg.Tokens {
+		if t.Name == "" {
+			errs = append(errs, fmt.Errorf("token without name"))
+			continue
+		}
+		if _, ok := p.tokens[t.Name]; ok {
+			errs = append(errs, fmt.Errorf("duplicate token '%v'", t.Name))
+			continue
+		}
+		if t.File != "" {
+			f, err := secret.NewFile(t.File)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed reading token '%v': %w", t.Name, err))
+			} else {
+				p.tokenFiles[t.Name] = f
+				t.Value = f.Value()
+			}
+		} else if t.Value == "" {
+			errs = append(errs, fmt.Errorf("token '%v' is empty", t.Name))
+		}
+		p.tokens[t.Name] = t.Value
+	}
+
+	var err error
+	if p.trustedProxies, err = ParseNetworks(strings.Join(cfg.TrustedProxies, ",")); err != nil {
+		errs = append(errs, err)
+	}
+
+	if p.defaultRule, err = p.newRule(cfg.Default); err != nil {
+		errs = append(errs, err)
+	}
+	for _, r := range cfg.Rules {
+		if !strings.HasPrefix(r.Path, "/") {
+			errs = append(errs, fmt.Errorf("invalid rule path: '%v'", r.Path))
+			continue
+		}
+		if _, ok := p.rules[r.Path]; ok {
+			errs = append(errs, fmt.Errorf("duplicate rule for path '%v'", r.Path))
+			continue
+		}
+		if p.rules[r.Path], err = p.newRule(r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return p, nil
+}
+
+func (p *Policy) newRule(r Rule) (*rule, error) {
+	var errs []error
+	for _, name := range r.Tokens {
+		if _, ok := p.tokens[name]; !ok {
+			errs = append(errs, fmt.Errorf("unknown token '%v' in rule for path '%v'", name, r.Path))
+		}
+	}
+
+	allow, err := ParseNetworks(strings.Join(r.Allow, ","))
+	if err != nil {
+		errs = append(errs, err)
+	}
+	deny, err := ParseNetworks(strings.Join(r.Deny, ","))
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	tokens := r.Tokens
+	if len(tokens) == 0 {
+		for name := range p.tokens {
+			tokens = append(tokens, name)
+		}
+	}
+
+	return &rule{tokens: tokens, allow: allow, deny: deny}, nil
+}
+
+// ParseNetworks parses a comma-separated list of IPs and CIDR ranges.
+func ParseNetworks(input string) ([]*net.IPNet, error) {
+	if input == "" {
+		return nil, nil
+	}
+	entries := strings.Split(input, ",")
+	var result []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			result = append(result, &net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(bits, bits),
+			})
+			continue
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			result = append(result, cidr)
+			continue
+		}
+		return nil, fmt.Errorf("Invalid IP or CIDR : %s", entry)
+	}
+	return result, nil
+}
+
+// SetAuditor sets the Auditor recording denied requests.
+func (p *Policy) SetAuditor(a *Auditor) {
+	p.auditor = a
+}
+
+// Protect wraps next with the rule of the request path.
+func (p *Policy) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reason, ok := p.Check(r); !ok {
+			if p.auditor != nil {
+				p.auditor.Denied(r, p.ClientIP(r).String(), reason)
+			}
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Check returns whether the request is allowed by the rule of its path, and the reason when it is not.
+func (p *Policy) Check(r *http.Request) (string, bool) {
+	ru := p.ruleFor(r.URL.Path)
+
+	// no filters defined → PASS
+	if len(ru.tokens) == 0 && len(ru.allow) == 0 && len(ru.deny) == 0 {
+		return "", true
+	}
+
+	ip := p.ClientIP(r)
+
+	// denied CIDR or IP → FAIL
+	if contains(ru.deny, ip) {
+		return ReasonIPDenied, false
+	}
+
+	// token valid → PASS
+	token := r.Header.Get("X-Auth-Token")
+	if token != "" {
+		for _, name := range ru.tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(p.token(name))) == 1 {
+				return "", true
+			}
+		}
+	}
+
+	// valid CIDR or IP → PASS
+	if contains(ru.allow, ip) {
+		return "", true
+	}
+
+	// only a deny list defined → PASS
+	if len(ru.tokens) == 0 && len(ru.allow) == 0 {
+		return "", true
+	}
+
+	if token != "" {
+		return ReasonBadToken, false
+	}
+	return ReasonIPNotAllowed, false
+}
+
+// Watch re-reads the token files every interval until ctx is done.
+func (p *Policy) Watch(ctx context.Context, interval time.Duration) {
+	for name, f := range p.tokenFiles {
+		name := name
+		go f.Watch(ctx, interval, func(value string) {
+			p.tokenMutex.Lock()
+			defer p.tokenMutex.Unlock()
+			p.tokens[name] = value
+		})
+	}
+}
+
+// token returns the value of the named token.
+func (p *Policy) token(name string) string {
+	p.tokenMutex.RLock()
+	defer p.tokenMutex.RUnlock()
+	return p.tokens[name]
+}
+
+// ruleFor returns the rule of the path, preferring exact matches over the longest prefix.
+func (p *Policy) ruleFor(path string) *rule {
+	if ru, ok := p.rules[path]; ok {
+		return ru
+	}
+	var match string
+	for prefix := range p.rules {
+		if strings.HasSuffix(prefix, "/") && strings.HasPrefix(path, prefix) && len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+	if match != "" {
+		return p.rules[match]
+	}
+	return p.defaultRule
+}
+
+// ClientIP returns the IP of the client, taken from X-Forwarded-For when the request came through a trusted proxy.
+func (p *Policy) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !contains(p.trustedProxies, ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+
+	// walk from the closest hop and stop at the first one not being a trusted proxy
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !contains(p.trustedProxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range networks {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
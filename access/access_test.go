@@ -0,0 +1,243 @@
+package access
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPolicy(t *testing.T) {
+	policy, err := NewPolicy(Config{
+		Tokens: []Token{
+			{Name: "prometheus", Value: "secret-token"},
+			{Name: "admin", Value: "admin-token"},
+		},
+		TrustedProxies: []string{"172.16.0.1"},
+		Default: Rule{
+			Allow: []string{"192.168.1.0/24", "10.0.0.1", "2001:db8::1"},
+			Deny:  []string{"192.168.1.13"},
+		},
+		Rules: []Rule{
+			{Path: "/-/", Tokens: []string{"admin"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error in test setup: %v", err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	protected := policy.Protect(handler)
+
+	tests := []struct {
+		name         string
+		path         string
+		remoteAddr   string
+		forwardedFor string
+		authToken    string
+		wantCode     int
+	}{
+		{"Valid IP match subnet", "/metrics", "192.168.1.42:1234", "", "", http.StatusOK},
+		{"Valid IP exact match", "/metrics", "10.0.0.1:9999", "", "", http.StatusOK},
+		{"Valid IPv6 exact match", "/metrics", "[2001:db8::1]:9999", "", "", http.StatusOK},
+		{"Invalid IPv6 in same /32", "/metrics", "[2001:db8::2]:9999", "", "", http.StatusForbidden},
+		{"Valid token", "/metrics", "8.8.8.8:1111", "", "secret-token", http.StatusOK},
+		{"Invalid IP and token", "/metrics", "8.8.8.8:1111", "", "wrong-token", http.StatusForbidden},
+		{"No auth at all", "/metrics", "8.8.8.8:1111", "", "", http.StatusForbidden},
+		{"Denied IP with valid token", "/metrics", "192.168.1.13:1111", "", "secret-token", http.StatusForbidden},
+		{"Forwarded by trusted proxy", "/metrics", "172.16.0.1:1111", "10.0.0.1", "", http.StatusOK},
+		{"Forwarded by untrusted proxy", "/metrics", "8.8.8.8:1111", "10.0.0.1", "", http.StatusForbidden},
+		{"Spoofed hop before trusted proxy", "/metrics", "172.16.0.1:1111", "10.0.0.1, 8.8.8.8", "", http.StatusForbidden},
+		{"Path rule token", "/-/reload", "8.8.8.8:1111", "", "admin-token", http.StatusOK},
+		{"Path rule other token", "/-/reload", "8.8.8.8:1111", "", "secret-token", http.StatusForbidden},
+		{"Path rule ignores default IPs", "/-/reload", "10.0.0.1:1111", "", "", http.StatusForbidden},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.path, nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+			if tc.authToken != "" {
+				req.Header.Set("X-Auth-Token", tc.authToken)
+			}
+
+			rec := httptest.NewRecorder()
+			protected.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantCode {
+				t.Errorf("Expected %d, got %d", tc.wantCode, rec.Code)
+			}
+		})
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []*net.IPNet
+		wantErr  bool
+	}{
+		{
+			name:     "empty string",
+			input:    "",
+			expected: nil,
+			wantErr:  false,
+		},
+		{
+			name:  "valid single IP",
+			input: "192.168.1.10",
+			expected: []*net.IPNet{
+				{
+					IP:   net.ParseIP("192.168.1.10"),
+					Mask: net.CIDRMask(32, 32),
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "valid CIDR",
+			input: "10.0.0.0/8",
+			expected: func() []*net.IPNet {
+				_, cidr, _ := net.ParseCIDR("10.0.0.0/8")
+				return []*net.IPNet{cidr}
+			}(),
+			wantErr: false,
+		},
+		{
+			name:  "valid mix IP and CIDR",
+			input: "192.168.1.1,10.0.0.0/24",
+			expected: func() []*net.IPNet {
+				_, cidr, _ := net.ParseCIDR("10.0.0.0/24")
+				return []*net.IPNet{
+					{
+						IP:   net.ParseIP("192.168.1.1"),
+						Mask: net.CIDRMask(32, 32),
+					},
+					cidr,
+				}
+			}(),
+			wantErr: false,
+		},
+		{
+			name:  "valid single IPv6",
+			input: "2001:db8::1",
+			expected: []*net.IPNet{
+				{
+					IP:   net.ParseIP("2001:db8::1"),
+					Mask: net.CIDRMask(128, 128),
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "invalid entry",
+			input:   "not-an-ip",
+			wantErr: true,
+		},
+		{
+			name:    "mixed valid and invalid",
+			input:   "192.168.1.1,not-an-ip",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseNetworks(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error = %v, got = %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestAuditor(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	auditor := NewAuditor(prometheus.NewRegistry(), 2, time.Hour)
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	for i := 0; i < 5; i++ {
+		auditor.Denied(req, "192.0.2.1", ReasonBadToken)
+	}
+
+	if got := testutil.ToFloat64(auditor.denied.WithLabelValues(ReasonBadToken)); got != 5 {
+		t.Errorf("expected 5 denied requests, got %v", got)
+	}
+	if got := strings.Count(logs.String(), "audit: denied request"); got != 2 {
+		t.Errorf("expected 2 audit log entries, got %d", got)
+	}
+	if auditor.suppressed != 3 {
+		t.Errorf("expected 3 suppressed entries, got %d", auditor.suppressed)
+	}
+}
+
+func TestTokenFileWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("first-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := NewPolicy(Config{Tokens: []Token{{Name: "file", File: path}}, Default: Rule{Allow: []string{"10.0.0.1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	policy.Watch(ctx, 10*time.Millisecond)
+
+	check := func(token string) bool {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.RemoteAddr = "8.8.8.8:1111"
+		r.Header.Set("X-Auth-Token", token)
+		_, ok := policy.Check(r)
+		return ok
+	}
+	if !check("first-token") {
+		t.Error("expected the token of the file to be accepted")
+	}
+
+	if err := os.WriteFile(path, []byte("second-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for !check("second-token") && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !check("second-token") || check("first-token") {
+		t.Error("expected the changed token to replace the old one")
+	}
+
+	// an unreadable file keeps the last token
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if !check("second-token") {
+		t.Error("expected the last token to be kept")
+	}
+}
@@ -0,0 +1,63 @@
+package access
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Auditor logs and counts denied requests. Log entries are limited to a number per interval,
+// the entries dropped in between are reported with the next logged one.
+type Auditor struct {
+	denied *prometheus.CounterVec
+
+	limit      int
+	interval   time.Duration
+	mutex      sync.Mutex
+	window     time.Time
+	logged     int
+	suppressed int
+}
+
+// NewAuditor creates an Auditor logging up to limit entries per interval, a limit of 0 disables logging.
+func NewAuditor(reg prometheus.Registerer, limit int, interval time.Duration) *Auditor {
+	a := &Auditor{
+		denied: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "freeradius_exporter_http_denied_total",
+			Help: "Total HTTP requests denied by the access policy",
+		}, []string{"reason"}),
+		limit:    limit,
+		interval: interval,
+	}
+	for _, reason := range []string{ReasonIPDenied, ReasonBadToken, ReasonIPNotAllowed} {
+		a.denied.WithLabelValues(reason)
+	}
+	reg.MustRegister(a.denied)
+	return a
+}
+
+// Denied records a request denied for reason.
+func (a *Auditor) Denied(r *http.Request, clientIP string, reason string) {
+	a.denied.WithLabelValues(reason).Inc()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(a.window) >= a.interval {
+		a.window = now
+		a.logged = 0
+	}
+	if a.logged >= a.limit {
+		a.suppressed++
+		return
+	}
+	a.logged++
+
+	log.Printf("audit: denied request remote=%v client=%v method=%v path=%q reason=%v suppressed=%d",
+		r.RemoteAddr, clientIP, r.Method, r.URL.Path, reason, a.suppressed)
+	a.suppressed = 0
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/bvantagelimited/freeradius_exporter/client"
+	"github.com/bvantagelimited/freeradius_exporter/modules"
+	"github.com/bvantagelimited/freeradius_exporter/secret"
+)
+
+// checkConfig validates the flags, environment variables and config file given with args, printing all the
+// problems found to w. It returns the exit code, 1 when there are problems.
+func checkConfig(args []string, w io.Writer) int {
+	c, _, err := parseConfig(args)
+	if err != nil {
+		fmt.Fprintf(w, "- %v\n1 problem found\n", err)
+		return 1
+	}
+
+	problems := configProblems(c)
+	if len(problems) == 0 {
+		fmt.Fprintln(w, "Config OK")
+		return 0
+	}
+	for _, p := range problems {
+		fmt.Fprintf(w, "- %v\n", p)
+	}
+	if len(problems) == 1 {
+		fmt.Fprintln(w, "1 problem found")
+	} else {
+		fmt.Fprintf(w, "%d problems found\n", len(problems))
+	}
+	return 1
+}
+
+// configProblems returns all the problems of the config, where starting the exporter stops at the first one.
+func configProblems(c *config) []error {
+	var problems []error
+	add := func(err error) {
+		problems = append(problems, unjoin(err)...)
+	}
+
+	if _, _, err := net.SplitHostPort(c.radiusAddr); err != nil {
+		add(fmt.Errorf("radius.address: %w", err))
+	}
+	for _, err := range c.radiusProblems() {
+		add(err)
+	}
+
+	if path := secret.Lookup(c.radiusSecretFile, "radius-secret"); path != "" {
+		if f, err := secret.NewFile(path); err != nil {
+			add(fmt.Errorf("radius.secret-file: %w", err))
+		} else if len(secret.Lines(f.Value())) == 0 {
+			add(fmt.Errorf("radius.secret-file: no secret in '%v'", path))
+		}
+	} else if c.radiusSecret == "" {
+		add(fmt.Errorf("radius.secret: no secret given"))
+	}
+
+	// the entries are trimmed and the empty ones skipped, like client.ParseHomeServers does
+	for _, s := range strings.Split(c.homeServers, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if _, err := client.ParseHomeServer(s, client.Options{}); err != nil {
+			add(fmt.Errorf("radius.homeservers: %w", err))
+		}
+	}
+
+	if c.configFile != "" {
+		if data, err := os.ReadFile(c.configFile); err != nil {
+			add(fmt.Errorf("config: %w", err))
+		} else if _, errs := modules.Parse(data); len(errs) > 0 {
+			for _, err := range errs {
+				add(fmt.Errorf("config '%v': %w", c.configFile, err))
+			}
+		}
+	}
+
+	if _, err := newAccessPolicy(c.accessConfig, c.authToken, secret.Lookup(c.authTokenFile, "web-auth-token"), c.allowedIPs, c.deniedIPs, c.trustedProxies); err != nil {
+		for _, e := range unjoin(err) {
+			add(fmt.Errorf("web access: %w", e))
+		}
+	}
+
+	if c.webhookURLs != "" {
+		if c.webhookTimeout <= 0 {
+			add(fmt.Errorf("webhook.timeout: must be positive, got %v", c.webhookTimeout))
+		}
+		if c.pollInterval <= 0 {
+			add(fmt.Errorf("webhook.urls: requires radius.poll-interval"))
+		}
+		for _, u := range strings.Split(c.webhookURLs, ",") {
+			if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				add(fmt.Errorf("webhook.urls: invalid URL '%v'", u))
+			}
+		}
+	}
+	if c.eventsSize <= 0 {
+		add(fmt.Errorf("events.size: must be positive, got %v", c.eventsSize))
+	}
+
+	// the client is only built when the parts are valid, to find what is left, e.g. home servers not resolving
+	if len(problems) == 0 {
+		if _, _, err := newRadiusClient(c); err != nil {
+			add(err)
+		}
+	}
+	return problems
+}
+
+// unjoin returns the errors joined in err, or err alone.
+func unjoin(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var errs []error
+	for _, e := range joined.Unwrap() {
+		errs = append(errs, unjoin(e)...)
+	}
+	return errs
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	var out bytes.Buffer
+	if code := checkConfig([]string{"-radius.homeservers", "172.28.1.2:1812:auth"}, &out); code != 0 {
+		t.Errorf("expected exit code 0, got %d: %v", code, out.String())
+	}
+
+	// a space after a comma and a trailing comma are accepted, as when starting the exporter
+	out.Reset()
+	if code := checkConfig([]string{"-radius.homeservers", "172.28.1.2:1812:auth, 172.28.1.3:1813:acct,"}, &out); code != 0 {
+		t.Errorf("expected exit code 0, got %d: %v", code, out.String())
+	}
+
+	out.Reset()
+	code := checkConfig([]string{
+		"-radius.address", "127.0.0.1",
+		"-radius.homeservers", "172.28.1.2:1812:proxy,172.28.1.3",
+		"-web.allowed-ips", "10.0.0.0/33",
+		"-events.size", "0",
+	}, &out)
+	if code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	for _, expected := range []string{
+		"radius.address:",
+		"unknown server type: 'proxy'",
+		"failed parsing home server ip ('172.28.1.3')",
+		"web access: Invalid IP or CIDR : 10.0.0.0/33",
+		"events.size:",
+		"5 problems found",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected output to contain %q, got:\n%v", expected, out.String())
+		}
+	}
+}
+
+func TestCheckConfigRetryFlags(t *testing.T) {
+	var out bytes.Buffer
+	code := checkConfig([]string{
+		"-radius.retry-interval", "-1",
+		"-radius.retry-backoff", "0.5",
+		"-radius.poll-interval", "-1",
+		"-webhook.urls", "http://127.0.0.1:8080/events",
+		"-webhook.timeout", "0",
+	}, &out)
+	if code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	for _, expected := range []string{
+		"radius.retry-interval: must not be negative, got -1",
+		"radius.retry-backoff: must be at least 1, got 0.5",
+		"radius.poll-interval: must not be negative, got -1",
+		"webhook.timeout: must be positive, got 0",
+		"webhook.urls: requires radius.poll-interval",
+		"5 problems found",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected output to contain %q, got:\n%v", expected, out.String())
+		}
+	}
+}
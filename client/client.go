@@ -4,99 +4,183 @@ import (
 	"context"
 	"crypto/hmac"
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bvantagelimited/freeradius_exporter/freeradius"
-	"github.com/prometheus/client_golang/prometheus"
 	"layeh.com/radius"
 	"layeh.com/radius/rfc2869"
 )
 
 // Statistics type.
 type Statistics struct {
-	Error           string
-	Access          Access
-	Auth            Auth
-	ProxyAccess     Access
-	ProxyAuth       Auth
-	Accounting      Accounting
-	ProxyAccounting Accounting
-	Internal        Internal
-	Server          Server
+	Error           string     `json:"stats_error"`
+	Access          Access     `json:"access"`
+	Auth            Auth       `json:"auth"`
+	ProxyAccess     Access     `json:"proxy_access"`
+	ProxyAuth       Auth       `json:"proxy_auth"`
+	Accounting      Accounting `json:"accounting"`
+	ProxyAccounting Accounting `json:"proxy_accounting"`
+	Internal        Internal   `json:"internal"`
+	Server          Server     `json:"server"`
 }
 
 // Server specific stats.
 type Server struct {
-	OutstandingRequests uint32
-	State               uint32
-	TimeOfDeath         time.Time
-	TimeOfLife          time.Time
-	LastPacketRecv      time.Time
-	LastPacketSent      time.Time
-	StartTime           time.Time
-	HUPTime             time.Time
-	EmaWindow           uint32
-	EmaUsecWindow1      uint32
-	EmaUsecWindow10     uint32
-	QueuePPSIn          uint32
-	QueuePPSOut         uint32
-	QueueUsePercentage  uint32
+	OutstandingRequests uint32    `json:"outstanding_requests"`
+	State               uint32    `json:"state"`
+	TimeOfDeath         time.Time `json:"time_of_death"`
+	TimeOfLife          time.Time `json:"time_of_life"`
+	LastPacketRecv      time.Time `json:"last_packet_recv"`
+	LastPacketSent      time.Time `json:"last_packet_sent"`
+	StartTime           time.Time `json:"start_time"`
+	HUPTime             time.Time `json:"hup_time"`
+	EmaWindow           uint32    `json:"ema_window"`
+	EmaUsecWindow1      uint32    `json:"ema_usec_window_1"`
+	EmaUsecWindow10     uint32    `json:"ema_usec_window_10"`
+	QueuePPSIn          uint32    `json:"queue_pps_in"`
+	QueuePPSOut         uint32    `json:"queue_pps_out"`
+	QueueUsePercentage  uint32    `json:"queue_use_percentage"`
 }
 
 // Access type.
 type Access struct {
-	Requests   uint32
-	Accepts    uint32
-	Rejects    uint32
-	Challenges uint32
+	Requests   uint32 `json:"requests"`
+	Accepts    uint32 `json:"accepts"`
+	Rejects    uint32 `json:"rejects"`
+	Challenges uint32 `json:"challenges"`
 }
 
 // Auth type.
 type Auth struct {
-	Responses         uint32
-	DuplicateRequests uint32
-	MalformedRequests uint32
-	InvalidRequests   uint32
-	DroppedRequests   uint32
-	UnknownTypes      uint32
+	Responses         uint32 `json:"responses"`
+	DuplicateRequests uint32 `json:"duplicate_requests"`
+	MalformedRequests uint32 `json:"malformed_requests"`
+	InvalidRequests   uint32 `json:"invalid_requests"`
+	DroppedRequests   uint32 `json:"dropped_requests"`
+	UnknownTypes      uint32 `json:"unknown_types"`
 }
 
 // Accounting type.
 type Accounting struct {
-	Requests          uint32
-	Responses         uint32
-	DuplicateRequests uint32
-	MalformedRequests uint32
-	InvalidRequests   uint32
-	DroppedRequests   uint32
-	UnknownTypes      uint32
+	Requests          uint32 `json:"requests"`
+	Responses         uint32 `json:"responses"`
+	DuplicateRequests uint32 `json:"duplicate_requests"`
+	MalformedRequests uint32 `json:"malformed_requests"`
+	InvalidRequests   uint32 `json:"invalid_requests"`
+	DroppedRequests   uint32 `json:"dropped_requests"`
+	UnknownTypes      uint32 `json:"unknown_types"`
 }
 
 // Internal type.
 type Internal struct {
-	QueueLenInternal uint32
-	QueueLenProxy    uint32
-	QueueLenAuth     uint32
-	QueueLenAcct     uint32
-	QueueLenDetail   uint32
+	QueueLenInternal uint32 `json:"queue_len_internal"`
+	QueueLenProxy    uint32 `json:"queue_len_proxy"`
+	QueueLenAuth     uint32 `json:"queue_len_auth"`
+	QueueLenAcct     uint32 `json:"queue_len_acct"`
+	QueueLenDetail   uint32 `json:"queue_len_detail"`
 }
 
 // FreeRADIUSClient fetches metrics from status server.
 type FreeRADIUSClient struct {
 	mainAddr string
 	packets  []packetWrapper
-	timeout  time.Duration
-	metrics  map[string]*prometheus.Desc
+	secrets  *secretGroup
+	mutex    sync.RWMutex
+}
+
+// ErrStatusServerUnreachable is the error of the home servers queried through the status server when
+// it could not be reached.
+var ErrStatusServerUnreachable = errors.New("status server could not be reached")
+
+// Options holds the settings of the status requests, used as defaults for the home servers.
+type Options struct {
+	// Secrets are tried in order until the server answers.
+	Secrets []string
+	Timeout time.Duration
+	Retry   RetryPolicy
+}
+
+// RetryPolicy controls how a request is sent again after a timeout.
+type RetryPolicy struct {
+	// Count is the number of times a request is sent again.
+	Count int
+	// Interval is the time waited before the first retry.
+	Interval time.Duration
+	// Backoff multiplies the interval after each retry.
+	Backoff float64
+}
+
+// TargetStatistics holds the statistics of the main server or a home server.
+type TargetStatistics struct {
+	Address string
+	// Type of a home server queried through the status server, as given or detected from its stats errors:
+	// "auth", "acct" or "auth+acct". Empty for the status server, directly probed home servers, and home servers
+	// whose type is not known yet.
+	Type       string
+	Statistics Statistics
+	// Duration of the exchange, including retries.
+	Duration time.Duration
+	// Err is set when the statistics could not be fetched.
+	Err error
+	// attributes contained in the response
+	attributes map[byte]bool
+	raw        []freeradius.VendorAttribute
+}
+
+// Has returns whether the response contained the FreeRADIUS statistics attribute, e.g. freeradius.ServerState.
+func (t TargetStatistics) Has(attr byte) bool {
+	return t.attributes[attr]
+}
+
+// Raw returns the FreeRADIUS attributes of the response as received, in order.
+func (t TargetStatistics) Raw() []freeradius.VendorAttribute {
+	return t.raw
+}
+
+// ExchangeCounters holds the retries and timeouts of the requests to a target.
+type ExchangeCounters struct {
+	Address  string
+	Retries  uint64
+	Timeouts uint64
+}
+
+// secretGroup is an ordered list of secrets, with the index of the one the server answered to last. The status
+// server and the home servers queried through it share a group, the directly probed home servers each have
+// their own, as they may answer to another secret.
+type secretGroup struct {
+	secrets []string
+	active  int
 }
 
+// packetWrapper holds the status request of a target, signed with each of the secrets of its group.
 type packetWrapper struct {
-	address string
-	packet  *radius.Packet
+	address  string
+	dest     string
+	statAttr radius.Attribute
+	packets  []*radius.Packet
+	secrets  *secretGroup
+	timeout  time.Duration
+	retry    RetryPolicy
+	counters *exchangeCounters
+	// ownSecrets is set for a directly probed home server with secrets of its own, not replaced by SetSecrets
+	ownSecrets bool
+	// homeType is the type of a home server queried through the status server,
+	// and detect is set while it is detected from the stats errors
+	homeType string
+	detect   bool
+}
+
+type exchangeCounters struct {
+	retries  atomic.Uint64
+	timeouts atomic.Uint64
 }
 
 func newPacket(secret []byte, address string, statAttr radius.Attribute) (*radius.Packet, error) {
@@ -154,464 +238,439 @@ func newPacket(secret []byte, address string, statAttr radius.Attribute) (*radiu
 	return packet, err
 }
 
+func newPacketWrapper(secrets *secretGroup, address, dest string, statAttr radius.Attribute, timeout time.Duration, retry RetryPolicy) (packetWrapper, error) {
+	p := packetWrapper{address: address, dest: dest, statAttr: statAttr, secrets: secrets, timeout: timeout, retry: retry, counters: &exchangeCounters{}}
+	for _, secret := range secrets.secrets {
+		packet, err := newPacket([]byte(secret), address, statAttr)
+		if err != nil {
+			return p, fmt.Errorf("failed creating new packet for address '%v': %w", address, err)
+		}
+		p.packets = append(p.packets, packet)
+	}
+	return p, nil
+}
+
+// rebuild returns a copy of p signed with secrets and asking for statAttr, keeping its counters.
+func (p packetWrapper) rebuild(secrets *secretGroup, statAttr radius.Attribute) (packetWrapper, error) {
+	rebuilt, err := newPacketWrapper(secrets, p.address, p.dest, statAttr, p.timeout, p.retry)
+	if err != nil {
+		return rebuilt, err
+	}
+	rebuilt.counters = p.counters
+	rebuilt.ownSecrets = p.ownSecrets
+	rebuilt.homeType = p.homeType
+	rebuilt.detect = p.detect
+	return rebuilt, nil
+}
+
+// homeServerStatAttr returns the statistics type asked for a home server of the given type.
+func homeServerStatAttr(homeType string) radius.Attribute {
+	switch homeType {
+	case "auth":
+		return radius.NewInteger(uint32(
+			freeradius.StatisticsTypeAuthentication |
+				freeradius.StatisticsTypeInternal |
+				freeradius.StatisticsTypeHomeServer,
+		))
+	case "acct":
+		return radius.NewInteger(uint32(
+			freeradius.StatisticsTypeAccounting |
+				freeradius.StatisticsTypeInternal |
+				freeradius.StatisticsTypeHomeServer,
+		))
+	}
+	return radius.NewInteger(uint32(
+		freeradius.StatisticsTypeAuthentication | // will give "Home server is not auth" stats error when server is acct (but won't fail and give the available metrics)
+			freeradius.StatisticsTypeAccounting | // will give "Home server is not acct" stats error when server is auth (but won't fail and give the available metrics)
+			freeradius.StatisticsTypeInternal |
+			freeradius.StatisticsTypeHomeServer,
+	))
+}
+
 // NewFreeRADIUSClient creates an FreeRADIUSClient.
-func NewFreeRADIUSClient(addr string, homeServers []string, secret string, timeout int) (*FreeRADIUSClient, error) {
+func NewFreeRADIUSClient(addr string, homeServers []HomeServer, opts Options) (*FreeRADIUSClient, error) {
+	if len(opts.Secrets) == 0 {
+		return nil, fmt.Errorf("no secret given")
+	}
 	client := &FreeRADIUSClient{}
 	client.mainAddr = addr
-	client.timeout = time.Duration(timeout) * time.Millisecond
-	client.metrics = metrics
-	packet, err := newPacket([]byte(secret), addr, radius.NewInteger(uint32(freeradius.StatisticsTypeAll)))
+	client.secrets = &secretGroup{secrets: opts.Secrets}
+	p, err := newPacketWrapper(client.secrets, addr, addr, radius.NewInteger(uint32(freeradius.StatisticsTypeAll)), opts.Timeout, opts.Retry)
 	if err != nil {
-		log.Fatalf("failed creating new packet for address '%v': %v\n", addr, err)
+		return nil, err
 	}
-	client.packets = append(client.packets, packetWrapper{packet: packet, address: addr})
+	client.packets = append(client.packets, p)
 
 	// add home server stats
 	for _, hs := range homeServers {
-		if hs == "" {
-			continue
-		}
-
-		statAttr := radius.NewInteger(uint32(
-			freeradius.StatisticsTypeAuthentication| // will give "Home server is not auth" stats error when server is acct (but won't fail and give the available metrics)
-			freeradius.StatisticsTypeAccounting| // will give "Home server is not acct" stats error when server is auth (but won't fail and give the available metrics)
-			freeradius.StatisticsTypeInternal|
-			freeradius.StatisticsTypeHomeServer,
-		))
-
-		if strings.Count(hs, ":") == 2 { // has third parameter
-			index := strings.LastIndex(hs, ":")
-			hsType := hs[index+1:]
-			hs = hs[:index]
-
-			if hsType == "auth" {
-				statAttr = radius.NewInteger(uint32(
-					freeradius.StatisticsTypeAuthentication|
-					freeradius.StatisticsTypeInternal|
-					freeradius.StatisticsTypeHomeServer,
-				))
-			} else if hsType == "acct" {
-				statAttr = radius.NewInteger(uint32(
-					freeradius.StatisticsTypeAccounting|
-					freeradius.StatisticsTypeInternal|
-					freeradius.StatisticsTypeHomeServer,
-				))
-			} else {
-				log.Fatalf("unknown server type: '%v'", hsType)
+		if hs.Direct {
+			// ask the home server for its own statistics, with the secrets of the status server unless it has its own,
+			// so that they follow SetSecrets
+			secrets := &secretGroup{secrets: opts.Secrets}
+			if len(hs.Secrets) > 0 {
+				secrets = &secretGroup{secrets: hs.Secrets}
+			}
+			p, err := newPacketWrapper(secrets, hs.Address, hs.Address, radius.NewInteger(uint32(freeradius.StatisticsTypeAll)), hs.Timeout, hs.Retry)
+			if err != nil {
+				return nil, err
 			}
+			p.ownSecrets = len(hs.Secrets) > 0
+			client.packets = append(client.packets, p)
+			continue
 		}
 
-		packet, err := newPacket([]byte(secret), hs, statAttr)
+		// the type of a home server given without one is detected from the stats errors of the first answers
+		p, err := newPacketWrapper(client.secrets, hs.Address, addr, homeServerStatAttr(hs.Type), hs.Timeout, hs.Retry)
 		if err != nil {
-			log.Fatalf("failed creating new packet for address '%v': %v\n", addr, err)
+			return nil, err
 		}
-		client.packets = append(client.packets, packetWrapper{packet: packet, address: hs})
+		p.homeType = hs.Type
+		p.detect = hs.Type == ""
+		client.packets = append(client.packets, p)
 	}
 
 	return client, nil
 }
 
-// Stats fetches statistics.
-func (f *FreeRADIUSClient) Stats() ([]prometheus.Metric, error) {
-	var allStats []prometheus.Metric
-
-	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
-	defer cancel()
-
-	for _, p := range f.packets {
-		stats := Statistics{}
-
-		response, err := radius.Exchange(ctx, p.packet, f.mainAddr)
-		if err != nil {
-			return nil, fmt.Errorf("exchange failed: %w", err)
-
-		}
-
-		if response.Code != radius.CodeAccessAccept {
-			return nil, fmt.Errorf("got response code '%v'", response.Code)
-		}
-
-		statsErr, err := freeradius.GetString(response, freeradius.StatsError)
-		if err == nil { // when there is no lookup error for this attribute, there is a freeradius-stats-error
-			log.Printf("error form stats server (main %v or home server: %v): '%v'", f.mainAddr, p.address, statsErr)
-		}
-
-		stats.Error = statsErr
-		m := prometheus.MustNewConstMetric(f.metrics["freeradius_stats_error"], prometheus.GaugeValue, 1, stats.Error, p.address)
-		allStats = append(allStats, m)
-
-		if stats.Server.LastPacketRecv, err = freeradius.GetDate(response, freeradius.LastPacketRecv); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_last_packet_recv"], prometheus.GaugeValue, float64(stats.Server.LastPacketRecv.Unix()), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-		if stats.Server.LastPacketSent, err = freeradius.GetDate(response, freeradius.LastPacketSent); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_last_packet_sent"], prometheus.GaugeValue, float64(stats.Server.LastPacketSent.Unix()), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.Server.HUPTime, err = freeradius.GetDate(response, freeradius.HUPTime); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_hup_time"], prometheus.GaugeValue, float64(stats.Server.HUPTime.Unix()), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-		if stats.Server.StartTime, err = freeradius.GetDate(response, freeradius.StartTime); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_start_time"], prometheus.GaugeValue, float64(stats.Server.StartTime.Unix()), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.Server.State, err = freeradius.GetInt(response, freeradius.ServerState); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_state"], prometheus.GaugeValue, float64(stats.Server.State), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.Server.TimeOfDeath, err = freeradius.GetDate(response, freeradius.ServerTimeOfDeath); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_time_of_death"], prometheus.GaugeValue, float64(stats.Server.TimeOfDeath.Unix()), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-		if stats.Server.TimeOfLife, err = freeradius.GetDate(response, freeradius.ServerTimeOfLife); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_time_of_life"], prometheus.GaugeValue, float64(stats.Server.TimeOfLife.Unix()), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.Server.EmaWindow, err = freeradius.GetInt(response, freeradius.EmaWindow); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_ema_window"], prometheus.GaugeValue, float64(stats.Server.EmaWindow), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.Server.EmaUsecWindow1, err = freeradius.GetInt(response, freeradius.EmaUsecWindow1); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_ema_window1_usec"], prometheus.GaugeValue, float64(stats.Server.EmaUsecWindow1), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.Server.EmaUsecWindow10, err = freeradius.GetInt(response, freeradius.EmaUsecWindow10); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_ema_window10_usec"], prometheus.GaugeValue, float64(stats.Server.EmaUsecWindow10), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.Server.OutstandingRequests, err = freeradius.GetInt(response, freeradius.ServerOutstandingRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_outstanding_requests"], prometheus.GaugeValue, float64(stats.Server.OutstandingRequests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.Server.QueuePPSIn, err = freeradius.GetInt(response, freeradius.QueuePPSIn); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_queue_pps_in"], prometheus.GaugeValue, float64(stats.Server.QueuePPSIn), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.Server.QueuePPSOut, err = freeradius.GetInt(response, freeradius.QueuePPSOut); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_queue_pps_out"], prometheus.GaugeValue, float64(stats.Server.QueuePPSOut), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.Server.QueueUsePercentage, err = freeradius.GetInt(response, freeradius.QueueUsePercentage); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_queue_use_percentage"], prometheus.GaugeValue, float64(stats.Server.QueuePPSOut), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.Access.Requests, err = freeradius.GetInt(response, freeradius.TotalAccessRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_access_requests"], prometheus.CounterValue, float64(stats.Access.Requests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.Access.Accepts, err = freeradius.GetInt(response, freeradius.TotalAccessAccepts); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_access_accepts"], prometheus.CounterValue, float64(stats.Access.Accepts), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.Access.Rejects, err = freeradius.GetInt(response, freeradius.TotalAccessRejects); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_access_rejects"], prometheus.CounterValue, float64(stats.Access.Rejects), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.Access.Challenges, err = freeradius.GetInt(response, freeradius.TotalAccessChallenges); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_access_challenges"], prometheus.CounterValue, float64(stats.Access.Challenges), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.Auth.Responses, err = freeradius.GetInt(response, freeradius.TotalAuthResponses); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_auth_responses"], prometheus.CounterValue, float64(stats.Auth.Responses), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.Auth.DuplicateRequests, err = freeradius.GetInt(response, freeradius.TotalAuthDuplicateRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_auth_duplicate_requests"], prometheus.CounterValue, float64(stats.Auth.DuplicateRequests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.Auth.MalformedRequests, err = freeradius.GetInt(response, freeradius.TotalAuthMalformedRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_auth_malformed_requests"], prometheus.CounterValue, float64(stats.Auth.MalformedRequests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
+// SetSecrets replaces the ordered list of client secrets used for the requests to the status server.
+func (f *FreeRADIUSClient) SetSecrets(secrets []string) error {
+	if len(secrets) == 0 {
+		return fmt.Errorf("no secret given")
+	}
 
-		if stats.Auth.InvalidRequests, err = freeradius.GetInt(response, freeradius.TotalAuthInvalidRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_auth_invalid_requests"], prometheus.CounterValue, float64(stats.Auth.InvalidRequests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
+	f.mutex.RLock()
+	current := f.packets
+	f.mutex.RUnlock()
 
-		if stats.Auth.DroppedRequests, err = freeradius.GetInt(response, freeradius.TotalAuthDroppedRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_auth_dropped_requests"], prometheus.CounterValue, float64(stats.Auth.DroppedRequests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.Auth.UnknownTypes, err = freeradius.GetInt(response, freeradius.TotalAuthUnknownTypes); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_auth_unknown_types"], prometheus.CounterValue, float64(stats.Auth.UnknownTypes), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
-
-		if stats.ProxyAccess.Requests, err = freeradius.GetInt(response, freeradius.TotalProxyAccessRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_proxy_access_requests"], prometheus.CounterValue, float64(stats.ProxyAccess.Requests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
+	group := &secretGroup{secrets: secrets}
+	packets := make([]packetWrapper, len(current))
+	for i, p := range current {
+		if p.ownSecrets {
+			packets[i] = p
+			continue
 		}
-
-		if stats.ProxyAccess.Accepts, err = freeradius.GetInt(response, freeradius.TotalProxyAccessAccepts); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_proxy_access_accepts"], prometheus.CounterValue, float64(stats.ProxyAccess.Accepts), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
+		// the directly probed home servers start over with the first secret, on their own
+		g := group
+		if p.dest != f.mainAddr {
+			g = &secretGroup{secrets: secrets}
 		}
-
-		if stats.ProxyAccess.Rejects, err = freeradius.GetInt(response, freeradius.TotalProxyAccessRejects); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_proxy_access_rejects"], prometheus.CounterValue, float64(stats.ProxyAccess.Rejects), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
+		var err error
+		if packets[i], err = p.rebuild(g, p.statAttr); err != nil {
+			return err
 		}
+	}
 
-		if stats.ProxyAccess.Challenges, err = freeradius.GetInt(response, freeradius.TotalProxyAccessChallenges); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_proxy_access_challenges"], prometheus.CounterValue, float64(stats.ProxyAccess.Challenges), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
+	f.mutex.Lock()
+	f.packets = packets
+	f.secrets = group
+	f.mutex.Unlock()
+	return nil
+}
 
-		if stats.ProxyAuth.Responses, err = freeradius.GetInt(response, freeradius.TotalProxyAuthResponses); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_proxy_auth_responses"], prometheus.CounterValue, float64(stats.ProxyAuth.Responses), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
+// ActiveSecretIndex returns the index of the secret the status server answered to last.
+func (f *FreeRADIUSClient) ActiveSecretIndex() int {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.packets[0].secrets.active
+}
 
-		if stats.ProxyAuth.DuplicateRequests, err = freeradius.GetInt(response, freeradius.TotalProxyAuthDuplicateRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_proxy_auth_duplicate_requests"], prometheus.CounterValue, float64(stats.ProxyAuth.DuplicateRequests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
+// exchange sends the status request of p, starting with the active secret and falling back
+// to the next ones when the server does not answer in time.
+func (f *FreeRADIUSClient) exchange(ctx context.Context, p packetWrapper) (*radius.Packet, error) {
+	f.mutex.RLock()
+	active := p.secrets.active
+	f.mutex.RUnlock()
+
+	var err error
+	for i := range p.packets {
+		index := (active + i) % len(p.packets)
+
+		var response *radius.Packet
+		response, err = f.send(ctx, p, p.packets[index])
+		if err == nil {
+			if index != active {
+				log.Printf("server %v answered to secret #%d", p.dest, index)
+				f.mutex.Lock()
+				p.secrets.active = index
+				f.mutex.Unlock()
+			}
+			return response, nil
 		}
-
-		if stats.ProxyAuth.MalformedRequests, err = freeradius.GetInt(response, freeradius.TotalProxyAuthMalformedRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_proxy_auth_malformed_requests"], prometheus.CounterValue, float64(stats.ProxyAuth.MalformedRequests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
+		if ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
 		}
+	}
+	return nil, err
+}
 
-		if stats.ProxyAuth.InvalidRequests, err = freeradius.GetInt(response, freeradius.TotalProxyAuthInvalidRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_proxy_auth_invalid_requests"], prometheus.CounterValue, float64(stats.ProxyAuth.InvalidRequests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
+// maxDuration returns how long the exchange of p can take, trying each secret with all retries.
+func (p packetWrapper) maxDuration() time.Duration {
+	d, interval := time.Duration(0), p.retry.Interval
+	for attempt := 0; attempt <= p.retry.Count; attempt++ {
+		d += p.timeout
+		if attempt < p.retry.Count {
+			d += interval
+			if p.retry.Backoff > 0 {
+				interval = time.Duration(float64(interval) * p.retry.Backoff)
+			}
 		}
+	}
+	return d * time.Duration(max(len(p.packets), 1))
+}
 
-		if stats.ProxyAuth.DroppedRequests, err = freeradius.GetInt(response, freeradius.TotalProxyAuthDroppedRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_proxy_auth_dropped_requests"], prometheus.CounterValue, float64(stats.ProxyAuth.DroppedRequests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
+// send sends packet to the server of p, sending it again on timeout as allowed by the retry policy.
+func (f *FreeRADIUSClient) send(ctx context.Context, p packetWrapper, packet *radius.Packet) (*radius.Packet, error) {
+	interval := p.retry.Interval
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		response, err := radius.Exchange(attemptCtx, packet, p.dest)
+		cancel()
+		if err == nil || !errors.Is(err, context.DeadlineExceeded) {
+			return response, err
 		}
 
-		if stats.ProxyAuth.UnknownTypes, err = freeradius.GetInt(response, freeradius.TotalProxyAuthUnknownTypes); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_proxy_auth_unknown_types"], prometheus.CounterValue, float64(stats.ProxyAuth.UnknownTypes), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
+		p.counters.timeouts.Add(1)
+		if attempt >= p.retry.Count || ctx.Err() != nil {
+			return nil, err
 		}
 
-		if stats.Accounting.Requests, err = freeradius.GetInt(response, freeradius.TotalAccountingRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_acct_requests"], prometheus.CounterValue, float64(stats.Accounting.Requests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
 		}
-
-		if stats.Accounting.Responses, err = freeradius.GetInt(response, freeradius.TotalAccountingResponses); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_acct_responses"], prometheus.CounterValue, float64(stats.Accounting.Responses), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
+		if p.retry.Backoff > 0 {
+			interval = time.Duration(float64(interval) * p.retry.Backoff)
 		}
+		p.counters.retries.Add(1)
+	}
+}
 
-		if stats.Accounting.DuplicateRequests, err = freeradius.GetInt(response, freeradius.TotalAcctDuplicateRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_acct_duplicate_requests"], prometheus.CounterValue, float64(stats.Accounting.DuplicateRequests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
+// detectType sets the type of the home server of p from the stats error of its answer, and switches
+// its request to the statistics of that type. It returns the type, empty while it is not known.
+func (f *FreeRADIUSClient) detectType(p packetWrapper, statsError string) string {
+	var homeType string
+	switch StatsErrorKind(statsError) {
+	case StatsErrorNotAuth:
+		homeType = "acct"
+	case StatsErrorNotAcct:
+		homeType = "auth"
+	case "":
+		homeType = "auth+acct"
+	default:
+		return ""
+	}
 
-		if stats.Accounting.MalformedRequests, err = freeradius.GetInt(response, freeradius.TotalAcctMalformedRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_acct_malformed_requests"], prometheus.CounterValue, float64(stats.Accounting.MalformedRequests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
+	detected, err := p.rebuild(p.secrets, homeServerStatAttr(homeType))
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	detected.homeType = homeType
+	detected.detect = false
 
-		if stats.Accounting.InvalidRequests, err = freeradius.GetInt(response, freeradius.TotalAcctInvalidRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_acct_invalid_requests"], prometheus.CounterValue, float64(stats.Accounting.InvalidRequests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for i, current := range f.packets {
+		// the secrets may have been replaced meanwhile, the type is then detected again with the next answer
+		if current.counters == p.counters && current.secrets == p.secrets {
+			f.packets[i] = detected
+			log.Printf("detected type %v of home server %v", homeType, p.address)
 		}
+	}
+	return homeType
+}
 
-		if stats.Accounting.DroppedRequests, err = freeradius.GetInt(response, freeradius.TotalAcctDroppedRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_acct_dropped_requests"], prometheus.CounterValue, float64(stats.Accounting.DroppedRequests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
+// ExchangeCounters returns the retries and timeouts of the requests to each target.
+func (f *FreeRADIUSClient) ExchangeCounters() []ExchangeCounters {
+	f.mutex.RLock()
+	packets := f.packets
+	f.mutex.RUnlock()
 
-		if stats.Accounting.UnknownTypes, err = freeradius.GetInt(response, freeradius.TotalAcctUnknownTypes); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_acct_unknown_types"], prometheus.CounterValue, float64(stats.Accounting.UnknownTypes), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
+	counters := make([]ExchangeCounters, len(packets))
+	for i, p := range packets {
+		counters[i] = ExchangeCounters{
+			Address:  p.address,
+			Retries:  p.counters.retries.Load(),
+			Timeouts: p.counters.timeouts.Load(),
 		}
+	}
+	return counters
+}
 
-		if stats.ProxyAccounting.Requests, err = freeradius.GetInt(response, freeradius.TotalProxyAccountingRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_proxy_acct_requests"], prometheus.CounterValue, float64(stats.ProxyAccounting.Requests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
+// Ping sends one status request to the status server with its active secret, without retries, and
+// returns whether it answered within its timeout. The exchange counters are left as they are.
+func (f *FreeRADIUSClient) Ping(ctx context.Context) error {
+	f.mutex.RLock()
+	p := f.packets[0]
+	active := p.secrets.active
+	f.mutex.RUnlock()
 
-		if stats.ProxyAccounting.Responses, err = freeradius.GetInt(response, freeradius.TotalProxyAccountingResponses); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_proxy_acct_responses"], prometheus.CounterValue, float64(stats.ProxyAccounting.Responses), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	response, err := radius.Exchange(ctx, p.packets[active], p.dest)
+	if err != nil {
+		return fmt.Errorf("exchange with %v failed: %w", p.address, err)
+	}
+	if response.Code != radius.CodeAccessAccept {
+		return fmt.Errorf("exchange with %v failed: got response code '%v'", p.address, response.Code)
+	}
+	return nil
+}
 
-		if stats.ProxyAccounting.DuplicateRequests, err = freeradius.GetInt(response, freeradius.TotalProxyAcctDuplicateRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_proxy_acct_duplicate_requests"], prometheus.CounterValue, float64(stats.ProxyAccounting.DuplicateRequests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
+// Stats fetches statistics.
+func (f *FreeRADIUSClient) Stats() ([]TargetStatistics, error) {
+	return f.StatsContext(context.Background())
+}
 
-		if stats.ProxyAccounting.MalformedRequests, err = freeradius.GetInt(response, freeradius.TotalProxyAcctMalformedRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_proxy_acct_malformed_requests"], prometheus.CounterValue, float64(stats.ProxyAccounting.MalformedRequests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
+// StatsContext fetches the statistics of all targets until ctx is done. Each target is returned,
+// with Err set when its statistics could not be fetched. The error is set when the status server
+// could not be reached, the home servers queried through it are then not sent a request.
+//
+// The fetch takes at most as long as the exchanges of all the targets can, with their retries and secrets.
+func (f *FreeRADIUSClient) StatsContext(ctx context.Context) ([]TargetStatistics, error) {
+	var allStats []TargetStatistics
+	var mainErr error
+
+	f.mutex.RLock()
+	packets := f.packets
+	f.mutex.RUnlock()
+
+	var limit time.Duration
+	for _, p := range packets {
+		limit += p.maxDuration()
+	}
+	ctx, cancel := context.WithTimeout(ctx, limit)
+	defer cancel()
 
-		if stats.ProxyAccounting.InvalidRequests, err = freeradius.GetInt(response, freeradius.TotalProxyAcctInvalidRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_proxy_acct_invalid_requests"], prometheus.CounterValue, float64(stats.ProxyAccounting.InvalidRequests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
+	for i, p := range packets {
+		if i > 0 && mainErr != nil && p.dest == packets[0].dest {
+			allStats = append(allStats, TargetStatistics{
+				Address: p.address,
+				Type:    p.homeType,
+				Err:     fmt.Errorf("exchange with %v skipped: %w", p.address, ErrStatusServerUnreachable),
+			})
+			continue
 		}
 
-		if stats.ProxyAccounting.DroppedRequests, err = freeradius.GetInt(response, freeradius.TotalProxyAcctDroppedRequests); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_proxy_acct_dropped_requests"], prometheus.CounterValue, float64(stats.ProxyAccounting.DroppedRequests), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
+		start := time.Now()
+		response, err := f.exchange(ctx, p)
+		if err == nil && response.Code != radius.CodeAccessAccept {
+			err = fmt.Errorf("got response code '%v'", response.Code)
 		}
 
-		if stats.ProxyAccounting.UnknownTypes, err = freeradius.GetInt(response, freeradius.TotalProxyAcctUnknownTypes); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_total_proxy_acct_unknown_types"], prometheus.CounterValue, float64(stats.ProxyAccounting.UnknownTypes), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
+		stats := TargetStatistics{Address: p.address, Type: p.homeType, Duration: time.Since(start)}
+		if err != nil {
+			stats.Err = fmt.Errorf("exchange with %v failed: %w", p.address, err)
+			if i == 0 {
+				mainErr = stats.Err
+			}
+		} else {
+			stats.Statistics, stats.attributes = parse(response)
+			stats.raw = freeradius.VendorAttributes(response)
+			if p.detect {
+				stats.Type = f.detectType(p, stats.Statistics.Error)
+			}
 		}
+		allStats = append(allStats, stats)
+	}
 
-		if stats.Internal.QueueLenInternal, err = freeradius.GetInt(response, freeradius.QueueLenInternal); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_queue_len_internal"], prometheus.GaugeValue, float64(stats.Internal.QueueLenInternal), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
+	return allStats, mainErr
+}
 
-		if stats.Internal.QueueLenProxy, err = freeradius.GetInt(response, freeradius.QueueLenProxy); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_queue_len_proxy"], prometheus.GaugeValue, float64(stats.Internal.QueueLenProxy), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
+// parse returns the statistics of the response, along with the attributes it contained.
+func parse(response *radius.Packet) (Statistics, map[byte]bool) {
+	stats := Statistics{}
+	attributes := make(map[byte]bool)
 
-		if stats.Internal.QueueLenAuth, err = freeradius.GetInt(response, freeradius.QueueLenAuth); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_queue_len_auth"], prometheus.GaugeValue, float64(stats.Internal.QueueLenAuth), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
-			log.Println(err)
-		}
+	var err error
+	if stats.Error, err = freeradius.GetString(response, freeradius.StatsError); err == nil {
+		attributes[freeradius.StatsError] = true
+	}
 
-		if stats.Internal.QueueLenAcct, err = freeradius.GetInt(response, freeradius.QueueLenAcct); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_queue_len_acct"], prometheus.GaugeValue, float64(stats.Internal.QueueLenAcct), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
+	dates := []struct {
+		attr  byte
+		value *time.Time
+	}{
+		{freeradius.LastPacketRecv, &stats.Server.LastPacketRecv},
+		{freeradius.LastPacketSent, &stats.Server.LastPacketSent},
+		{freeradius.HUPTime, &stats.Server.HUPTime},
+		{freeradius.StartTime, &stats.Server.StartTime},
+		{freeradius.ServerTimeOfDeath, &stats.Server.TimeOfDeath},
+		{freeradius.ServerTimeOfLife, &stats.Server.TimeOfLife},
+	}
+	for _, d := range dates {
+		if *d.value, err = freeradius.GetDate(response, d.attr); err == nil {
+			attributes[d.attr] = true
+		} else if err != radius.ErrNoAttribute {
 			log.Println(err)
 		}
+	}
 
-		if stats.Internal.QueueLenDetail, err = freeradius.GetInt(response, freeradius.QueueLenDetail); err == nil {
-			allStats = append(allStats, prometheus.MustNewConstMetric(f.metrics["freeradius_queue_len_detail"], prometheus.GaugeValue, float64(stats.Internal.QueueLenDetail), p.address))
-		} else if err != nil && err != radius.ErrNoAttribute {
+	ints := []struct {
+		attr  byte
+		value *uint32
+	}{
+		{freeradius.ServerState, &stats.Server.State},
+		{freeradius.EmaWindow, &stats.Server.EmaWindow},
+		{freeradius.EmaUsecWindow1, &stats.Server.EmaUsecWindow1},
+		{freeradius.EmaUsecWindow10, &stats.Server.EmaUsecWindow10},
+		{freeradius.ServerOutstandingRequests, &stats.Server.OutstandingRequests},
+		{freeradius.QueuePPSIn, &stats.Server.QueuePPSIn},
+		{freeradius.QueuePPSOut, &stats.Server.QueuePPSOut},
+		{freeradius.QueueUsePercentage, &stats.Server.QueueUsePercentage},
+
+		{freeradius.TotalAccessRequests, &stats.Access.Requests},
+		{freeradius.TotalAccessAccepts, &stats.Access.Accepts},
+		{freeradius.TotalAccessRejects, &stats.Access.Rejects},
+		{freeradius.TotalAccessChallenges, &stats.Access.Challenges},
+		{freeradius.TotalAuthResponses, &stats.Auth.Responses},
+		{freeradius.TotalAuthDuplicateRequests, &stats.Auth.DuplicateRequests},
+		{freeradius.TotalAuthMalformedRequests, &stats.Auth.MalformedRequests},
+		{freeradius.TotalAuthInvalidRequests, &stats.Auth.InvalidRequests},
+		{freeradius.TotalAuthDroppedRequests, &stats.Auth.DroppedRequests},
+		{freeradius.TotalAuthUnknownTypes, &stats.Auth.UnknownTypes},
+
+		{freeradius.TotalProxyAccessRequests, &stats.ProxyAccess.Requests},
+		{freeradius.TotalProxyAccessAccepts, &stats.ProxyAccess.Accepts},
+		{freeradius.TotalProxyAccessRejects, &stats.ProxyAccess.Rejects},
+		{freeradius.TotalProxyAccessChallenges, &stats.ProxyAccess.Challenges},
+		{freeradius.TotalProxyAuthResponses, &stats.ProxyAuth.Responses},
+		{freeradius.TotalProxyAuthDuplicateRequests, &stats.ProxyAuth.DuplicateRequests},
+		{freeradius.TotalProxyAuthMalformedRequests, &stats.ProxyAuth.MalformedRequests},
+		{freeradius.TotalProxyAuthInvalidRequests, &stats.ProxyAuth.InvalidRequests},
+		{freeradius.TotalProxyAuthDroppedRequests, &stats.ProxyAuth.DroppedRequests},
+		{freeradius.TotalProxyAuthUnknownTypes, &stats.ProxyAuth.UnknownTypes},
+
+		{freeradius.TotalAccountingRequests, &stats.Accounting.Requests},
+		{freeradius.TotalAccountingResponses, &stats.Accounting.Responses},
+		{freeradius.TotalAcctDuplicateRequests, &stats.Accounting.DuplicateRequests},
+		{freeradius.TotalAcctMalformedRequests, &stats.Accounting.MalformedRequests},
+		{freeradius.TotalAcctInvalidRequests, &stats.Accounting.InvalidRequests},
+		{freeradius.TotalAcctDroppedRequests, &stats.Accounting.DroppedRequests},
+		{freeradius.TotalAcctUnknownTypes, &stats.Accounting.UnknownTypes},
+
+		{freeradius.TotalProxyAccountingRequests, &stats.ProxyAccounting.Requests},
+		{freeradius.TotalProxyAccountingResponses, &stats.ProxyAccounting.Responses},
+		{freeradius.TotalProxyAcctDuplicateRequests, &stats.ProxyAccounting.DuplicateRequests},
+		{freeradius.TotalProxyAcctMalformedRequests, &stats.ProxyAccounting.MalformedRequests},
+		{freeradius.TotalProxyAcctInvalidRequests, &stats.ProxyAccounting.InvalidRequests},
+		{freeradius.TotalProxyAcctDroppedRequests, &stats.ProxyAccounting.DroppedRequests},
+		{freeradius.TotalProxyAcctUnknownTypes, &stats.ProxyAccounting.UnknownTypes},
+
+		{freeradius.QueueLenInternal, &stats.Internal.QueueLenInternal},
+		{freeradius.QueueLenProxy, &stats.Internal.QueueLenProxy},
+		{freeradius.QueueLenAuth, &stats.Internal.QueueLenAuth},
+		{freeradius.QueueLenAcct, &stats.Internal.QueueLenAcct},
+		{freeradius.QueueLenDetail, &stats.Internal.QueueLenDetail},
+	}
+	for _, i := range ints {
+		if *i.value, err = freeradius.GetInt(response, i.attr); err == nil {
+			attributes[i.attr] = true
+		} else if err != radius.ErrNoAttribute {
 			log.Println(err)
 		}
 	}
 
-	return allStats, nil
-}
-
-var metrics = map[string]*prometheus.Desc{
-	"freeradius_total_access_requests":               prometheus.NewDesc("freeradius_total_access_requests", "Total access requests", []string{"address"}, nil),
-	"freeradius_total_access_accepts":                prometheus.NewDesc("freeradius_total_access_accepts", "Total access accepts", []string{"address"}, nil),
-	"freeradius_total_access_rejects":                prometheus.NewDesc("freeradius_total_access_rejects", "Total access rejects", []string{"address"}, nil),
-	"freeradius_total_access_challenges":             prometheus.NewDesc("freeradius_total_access_challenges", "Total access challenges", []string{"address"}, nil),
-	"freeradius_total_auth_responses":                prometheus.NewDesc("freeradius_total_auth_responses", "Total auth responses", []string{"address"}, nil),
-	"freeradius_total_auth_duplicate_requests":       prometheus.NewDesc("freeradius_total_auth_duplicate_requests", "Total auth duplicate requests", []string{"address"}, nil),
-	"freeradius_total_auth_malformed_requests":       prometheus.NewDesc("freeradius_total_auth_malformed_requests", "Total auth malformed requests", []string{"address"}, nil),
-	"freeradius_total_auth_invalid_requests":         prometheus.NewDesc("freeradius_total_auth_invalid_requests", "Total auth invalid requests", []string{"address"}, nil),
-	"freeradius_total_auth_dropped_requests":         prometheus.NewDesc("freeradius_total_auth_dropped_requests", "Total auth dropped requests", []string{"address"}, nil),
-	"freeradius_total_auth_unknown_types":            prometheus.NewDesc("freeradius_total_auth_unknown_types", "Total auth unknown types", []string{"address"}, nil),
-	"freeradius_total_proxy_access_requests":         prometheus.NewDesc("freeradius_total_proxy_access_requests", "Total proxy access requests", []string{"address"}, nil),
-	"freeradius_total_proxy_access_accepts":          prometheus.NewDesc("freeradius_total_proxy_access_accepts", "Total proxy access accepts", []string{"address"}, nil),
-	"freeradius_total_proxy_access_rejects":          prometheus.NewDesc("freeradius_total_proxy_access_rejects", "Total proxy access rejects", []string{"address"}, nil),
-	"freeradius_total_proxy_access_challenges":       prometheus.NewDesc("freeradius_total_proxy_access_challenges", "Total proxy access challenges", []string{"address"}, nil),
-	"freeradius_total_proxy_auth_responses":          prometheus.NewDesc("freeradius_total_proxy_auth_responses", "Total proxy auth responses", []string{"address"}, nil),
-	"freeradius_total_proxy_auth_duplicate_requests": prometheus.NewDesc("freeradius_total_proxy_auth_duplicate_requests", "Total proxy auth duplicate requests", []string{"address"}, nil),
-	"freeradius_total_proxy_auth_malformed_requests": prometheus.NewDesc("freeradius_total_proxy_auth_malformed_requests", "Total proxy auth malformed requests", []string{"address"}, nil),
-	"freeradius_total_proxy_auth_invalid_requests":   prometheus.NewDesc("freeradius_total_proxy_auth_invalid_requests", "Total proxy auth invalid requests", []string{"address"}, nil),
-	"freeradius_total_proxy_auth_dropped_requests":   prometheus.NewDesc("freeradius_total_proxy_auth_dropped_requests", "Total proxy auth dropped requests", []string{"address"}, nil),
-	"freeradius_total_proxy_auth_unknown_types":      prometheus.NewDesc("freeradius_total_proxy_auth_unknown_types", "Total proxy auth unknown types", []string{"address"}, nil),
-	"freeradius_total_acct_requests":                 prometheus.NewDesc("freeradius_total_acct_requests", "Total acct requests", []string{"address"}, nil),
-	"freeradius_total_acct_responses":                prometheus.NewDesc("freeradius_total_acct_responses", "Total acct responses", []string{"address"}, nil),
-	"freeradius_total_acct_duplicate_requests":       prometheus.NewDesc("freeradius_total_acct_duplicate_requests", "Total acct duplicate requests", []string{"address"}, nil),
-	"freeradius_total_acct_malformed_requests":       prometheus.NewDesc("freeradius_total_acct_malformed_requests", "Total acct malformed requests", []string{"address"}, nil),
-	"freeradius_total_acct_invalid_requests":         prometheus.NewDesc("freeradius_total_acct_invalid_requests", "Total acct invalid requests", []string{"address"}, nil),
-	"freeradius_total_acct_dropped_requests":         prometheus.NewDesc("freeradius_total_acct_dropped_requests", "Total acct dropped requests", []string{"address"}, nil),
-	"freeradius_total_acct_unknown_types":            prometheus.NewDesc("freeradius_total_acct_unknown_types", "Total acct unknown types", []string{"address"}, nil),
-	"freeradius_total_proxy_acct_requests":           prometheus.NewDesc("freeradius_total_proxy_acct_requests", "Total proxy acct requests", []string{"address"}, nil),
-	"freeradius_total_proxy_acct_responses":          prometheus.NewDesc("freeradius_total_proxy_acct_responses", "Total proxy acct responses", []string{"address"}, nil),
-	"freeradius_total_proxy_acct_duplicate_requests": prometheus.NewDesc("freeradius_total_proxy_acct_duplicate_requests", "Total proxy acct duplicate requests", []string{"address"}, nil),
-	"freeradius_total_proxy_acct_malformed_requests": prometheus.NewDesc("freeradius_total_proxy_acct_malformed_requests", "Total proxy acct malformed requests", []string{"address"}, nil),
-	"freeradius_total_proxy_acct_invalid_requests":   prometheus.NewDesc("freeradius_total_proxy_acct_invalid_requests", "Total proxy acct invalid requests", []string{"address"}, nil),
-	"freeradius_total_proxy_acct_dropped_requests":   prometheus.NewDesc("freeradius_total_proxy_acct_dropped_requests", "Total proxy acct dropped requests", []string{"address"}, nil),
-	"freeradius_total_proxy_acct_unknown_types":      prometheus.NewDesc("freeradius_total_proxy_acct_unknown_types", "Total proxy acct unknown types", []string{"address"}, nil),
-	"freeradius_queue_len_internal":                  prometheus.NewDesc("freeradius_queue_len_internal", "Internal queue length", []string{"address"}, nil),
-	"freeradius_queue_len_proxy":                     prometheus.NewDesc("freeradius_queue_len_proxy", "Proxy queue length", []string{"address"}, nil),
-	"freeradius_queue_len_auth":                      prometheus.NewDesc("freeradius_queue_len_auth", "Auth queue length", []string{"address"}, nil),
-	"freeradius_queue_len_acct":                      prometheus.NewDesc("freeradius_queue_len_acct", "Acct queue length", []string{"address"}, nil),
-	"freeradius_queue_len_detail":                    prometheus.NewDesc("freeradius_queue_len_detail", "Detail queue length", []string{"address"}, nil),
-	"freeradius_last_packet_recv":                    prometheus.NewDesc("freeradius_last_packet_recv", "Epoch timestamp when the last packet was received", []string{"address"}, nil),
-	"freeradius_last_packet_sent":                    prometheus.NewDesc("freeradius_last_packet_sent", "Epoch timestamp when the last packet was sent", []string{"address"}, nil),
-	"freeradius_start_time":                          prometheus.NewDesc("freeradius_start_time", "Epoch timestamp when the server was started", []string{"address"}, nil),
-	"freeradius_hup_time":                            prometheus.NewDesc("freeradius_hup_time", "Epoch timestamp when the server hang up (If start == hup, it hasn't been hup'd yet)", []string{"address"}, nil),
-	"freeradius_state":                               prometheus.NewDesc("freeradius_state", "State of the server. Alive = 0; Zombie = 1; Dead = 2; Idle = 3", []string{"address"}, nil),
-	"freeradius_time_of_death":                       prometheus.NewDesc("freeradius_time_of_death", "Epoch timestamp when a home server is marked as 'dead'", []string{"address"}, nil),
-	"freeradius_time_of_life":                        prometheus.NewDesc("freeradius_time_of_life", "Epoch timestamp when a home server is marked as 'alive'", []string{"address"}, nil),
-	"freeradius_ema_window":                          prometheus.NewDesc("freeradius_ema_window", "Exponential moving average of home server response time", []string{"address"}, nil),
-	"freeradius_ema_window1_usec":                    prometheus.NewDesc("freeradius_ema_window1_usec", "Window-1 is the average is calculated over 'window' packets", []string{"address"}, nil),
-	"freeradius_ema_window10_usec":                   prometheus.NewDesc("freeradius_ema_window10_usec", "Window-10 is the average is calculated over '10 * window' packets", []string{"address"}, nil),
-	"freeradius_outstanding_requests":                prometheus.NewDesc("freeradius_outstanding_requests", "Outstanding requests", []string{"address"}, nil),
-	"freeradius_queue_pps_in":                        prometheus.NewDesc("freeradius_queue_pps_in", "Queue PPS in", []string{"address"}, nil),
-	"freeradius_queue_pps_out":                       prometheus.NewDesc("freeradius_queue_pps_out", "Queue PPS out", []string{"address"}, nil),
-	"freeradius_queue_use_percentage":                prometheus.NewDesc("freeradius_queue_use_percentage", "Queue usage percentage", []string{"address"}, nil),
-	"freeradius_stats_error":                         prometheus.NewDesc("freeradius_stats_error", "Stats error as label with a const value of 1", []string{"error", "address"}, nil),
+	return stats, attributes
 }
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bvantagelimited/freeradius_exporter/freeradius"
+	"github.com/bvantagelimited/freeradius_exporter/internal/radiustest"
+	"layeh.com/radius"
+)
+
+func TestSecretFallback(t *testing.T) {
+	addr := radiustest.NewServer(t, "new-secret", radiustest.Accept)
+
+	client, err := NewFreeRADIUSClient(addr, nil, Options{Secrets: []string{"old-secret", "new-secret"}, Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Stats(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := client.ActiveSecretIndex(); got != 1 {
+			t.Errorf("expected active secret index 1, got %d", got)
+		}
+	}
+
+	if err := client.SetSecrets([]string{"other-secret"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.Stats(); err == nil {
+		t.Errorf("expected error when no secret is accepted")
+	}
+}
+
+func TestSetSecretsDirectHomeServer(t *testing.T) {
+	statusAddr := radiustest.NewServer(t, "new-secret", radiustest.Accept)
+	sharedAddr := radiustest.NewServer(t, "new-secret", radiustest.Accept)
+	ownAddr := radiustest.NewServer(t, "home-secret", radiustest.Accept)
+
+	opts := Options{Secrets: []string{"old-secret"}, Timeout: 200 * time.Millisecond}
+	homeServers := []HomeServer{
+		{Address: sharedAddr, Direct: true, Options: Options{Timeout: opts.Timeout}},
+		{Address: ownAddr, Direct: true, Options: Options{Secrets: []string{"home-secret"}, Timeout: opts.Timeout}},
+	}
+	client, err := NewFreeRADIUSClient(statusAddr, homeServers, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stats, _ := client.Stats()
+	if stats[1].Err == nil {
+		t.Error("expected the direct home server to reject the old secret")
+	}
+
+	// the rotated secrets reach the direct home server without secrets of its own
+	if err := client.SetSecrets([]string{"new-secret"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stats, err = client.Stats()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, s := range stats {
+		if s.Err != nil {
+			t.Errorf("unexpected error of %v after the rotation: %v", s.Address, s.Err)
+		}
+	}
+}
+
+func TestActiveSecretPerServer(t *testing.T) {
+	statusAddr := radiustest.NewServer(t, "status-secret", radiustest.Accept)
+	homeAddr := radiustest.NewServer(t, "home-secret", radiustest.Accept)
+
+	opts := Options{Secrets: []string{"status-secret", "home-secret"}, Timeout: 100 * time.Millisecond}
+	client, err := NewFreeRADIUSClient(statusAddr, []HomeServer{{Address: homeAddr, Direct: true, Options: Options{Timeout: opts.Timeout}}}, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the home server falling back to the second secret does not move the status server off the first one
+	for i := 0; i < 3; i++ {
+		stats, err := client.Stats()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if stats[1].Err != nil {
+			t.Fatalf("unexpected error of the home server: %v", stats[1].Err)
+		}
+		if got := client.ActiveSecretIndex(); got != 0 {
+			t.Errorf("expected active secret index 0 of the status server, got %d", got)
+		}
+	}
+	counters := client.ExchangeCounters()
+	if counters[0].Timeouts != 0 || counters[1].Timeouts != 1 {
+		t.Errorf("expected a single timeout of the home server, got %+v", counters)
+	}
+
+	// after a rotation, each server finds its secret again on its own
+	if err := client.SetSecrets([]string{"home-secret", "status-secret"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := client.Stats(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got := client.ActiveSecretIndex(); got != 1 {
+		t.Errorf("expected active secret index 1 of the status server, got %d", got)
+	}
+	if counters := client.ExchangeCounters(); counters[0].Timeouts != 1 || counters[1].Timeouts != 1 {
+		t.Errorf("expected a single timeout of the status server after the rotation, got %+v", counters)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var requests atomic.Int32
+	addr := radiustest.NewServer(t, "secret", func(w radius.ResponseWriter, r *radius.Request) {
+		if requests.Add(1) == 1 {
+			return // drop the first request
+		}
+		radiustest.Accept(w, r)
+	})
+
+	client, err := NewFreeRADIUSClient(addr, nil, Options{
+		Secrets: []string{"secret"},
+		Timeout: 100 * time.Millisecond,
+		Retry:   RetryPolicy{Count: 2, Interval: 10 * time.Millisecond, Backoff: 2},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := client.Stats(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	counters := client.ExchangeCounters()
+	if len(counters) != 1 || counters[0].Retries != 1 || counters[0].Timeouts != 1 {
+		t.Errorf("expected 1 retry and 1 timeout, got %+v", counters)
+	}
+}
+
+func TestStatsContextPartial(t *testing.T) {
+	addr := radiustest.NewServer(t, "secret", radiustest.Accept)
+	silent := radiustest.NewServer(t, "secret", radiustest.Silent)
+
+	opts := Options{Secrets: []string{"secret"}, Timeout: time.Minute}
+	client, err := NewFreeRADIUSClient(addr, []HomeServer{{Address: silent, Direct: true, Options: opts}}, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	stats, err := client.StatsContext(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(stats) != 2 || stats[0].Err != nil || !errors.Is(stats[1].Err, context.DeadlineExceeded) {
+		t.Fatalf("expected home server exchange to exceed the deadline, got %+v", stats)
+	}
+	if !stats[0].Has(freeradius.TotalAccessRequests) || stats[0].Statistics.Access.Requests != 42 {
+		t.Errorf("expected 42 access requests, got %+v", stats[0].Statistics.Access)
+	}
+}
+
+func TestDetectHomeServerType(t *testing.T) {
+	var authRequests atomic.Int32
+	addr := radiustest.NewServer(t, "secret", func(w radius.ResponseWriter, r *radius.Request) {
+		response := r.Response(radius.CodeAccessAccept)
+		statType, _ := freeradius.GetInt(r.Packet, freeradius.StatisticsType)
+		if statType&freeradius.StatisticsTypeHomeServer != 0 && statType&freeradius.StatisticsTypeAuthentication != 0 {
+			authRequests.Add(1)
+			value, _ := radius.NewString("Home server is not auth")
+			freeradius.SetValue(response, freeradius.StatsError, value)
+		}
+		w.Write(response)
+	})
+
+	opts := Options{Secrets: []string{"secret"}, Timeout: time.Second}
+	client, err := NewFreeRADIUSClient(addr, []HomeServer{{Address: "127.0.0.1:1813", Options: opts}}, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		stats, err := client.Stats()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if stats[0].Type != "" || stats[1].Type != "acct" {
+			t.Errorf("expected home server type acct, got %+v", stats)
+		}
+	}
+	if got := authRequests.Load(); got != 1 {
+		t.Errorf("expected auth statistics to be asked once, got %d", got)
+	}
+}
+
+func TestStatsContextStatusServerUnreachable(t *testing.T) {
+	silent := radiustest.NewServer(t, "secret", radiustest.Silent)
+	direct := radiustest.NewServer(t, "secret", radiustest.Accept)
+
+	opts := Options{Secrets: []string{"secret"}, Timeout: 50 * time.Millisecond, Retry: RetryPolicy{Count: 1}}
+	homeServers := []HomeServer{
+		{Address: "10.0.0.1:1812", Type: "auth", Options: opts},
+		{Address: "10.0.0.2:1813", Type: "acct", Options: opts},
+		{Address: direct, Direct: true, Options: opts},
+	}
+	client, err := NewFreeRADIUSClient(silent, homeServers, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	start := time.Now()
+	stats, err := client.StatsContext(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the status server exchange to time out, got %v", err)
+	}
+	// the status server exchange takes two timeouts, the home servers behind it are not sent a request
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("expected the fetch to take about 100ms, took %v", elapsed)
+	}
+
+	if len(stats) != 4 {
+		t.Fatalf("expected 4 targets, got %+v", stats)
+	}
+	for _, s := range stats[1:3] {
+		if !errors.Is(s.Err, ErrStatusServerUnreachable) || s.Duration != 0 {
+			t.Errorf("expected home server %v to be skipped, got %+v", s.Address, s)
+		}
+	}
+	if stats[3].Err != nil {
+		t.Errorf("expected direct home server to answer, got %v", stats[3].Err)
+	}
+	if counters := client.ExchangeCounters(); counters[1].Timeouts != 0 || counters[2].Timeouts != 0 {
+		t.Errorf("expected no requests to the skipped home servers, got %+v", counters)
+	}
+}
+
+func TestStatsContextHomeServerTimeout(t *testing.T) {
+	addr := radiustest.NewServer(t, "secret", radiustest.Accept)
+	slow := radiustest.NewServer(t, "secret", func(w radius.ResponseWriter, r *radius.Request) {
+		time.Sleep(150 * time.Millisecond)
+		radiustest.Accept(w, r)
+	})
+
+	opts := Options{Secrets: []string{"secret"}, Timeout: 50 * time.Millisecond}
+	homeOpts := Options{Timeout: 300 * time.Millisecond}
+	client, err := NewFreeRADIUSClient(addr, []HomeServer{{Address: slow, Direct: true, Options: homeOpts}}, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the home server has its own timeout, longer than that of the status server
+	stats, err := client.StatsContext(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stats) != 2 || stats[1].Err != nil {
+		t.Fatalf("expected the slow home server to answer within its timeout, got %+v", stats)
+	}
+}
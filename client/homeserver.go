@@ -0,0 +1,116 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HomeServer is a home server whose statistics are fetched.
+type HomeServer struct {
+	Address string
+	// Type is "auth", "acct" or empty for both.
+	Type string
+	// Direct sends the status request to the home server itself instead of the status server,
+	// signed with the secrets of the home server, or those of the status server when it has none.
+	Direct bool
+	Options
+}
+
+// ParseHomeServer parses a home server given as 'host:port[:auth|acct][?option=value&...]'.
+// The options are timeout and retry-interval (in milliseconds), retries, retry-backoff, direct and secret,
+// which may be repeated for an ordered list of secrets and implies direct, so it cannot be given with
+// direct=false. Settings not given are taken from defaults, except the secrets: a direct home server
+// without secret shares those of the status server.
+func ParseHomeServer(s string, defaults Options) (HomeServer, error) {
+	hs := HomeServer{Options: defaults}
+	hs.Secrets = nil
+
+	addr, query, _ := strings.Cut(s, "?")
+	if strings.Count(addr, ":") == 2 { // has third parameter
+		index := strings.LastIndex(addr, ":")
+		hs.Type = addr[index+1:]
+		addr = addr[:index]
+
+		if hs.Type != "auth" && hs.Type != "acct" {
+			return hs, fmt.Errorf("unknown server type: '%v'", hs.Type)
+		}
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return hs, fmt.Errorf("failed parsing home server ip ('%v'): %w", addr, err)
+	}
+	hs.Address = addr
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return hs, fmt.Errorf("failed parsing options of home server '%v': %w", addr, err)
+	}
+	for name, value := range values {
+		last := value[len(value)-1]
+		switch name {
+		case "timeout":
+			timeout, err := strconv.Atoi(last)
+			if err != nil || timeout <= 0 {
+				return hs, fmt.Errorf("invalid timeout of home server '%v': '%v'", addr, last)
+			}
+			hs.Timeout = time.Duration(timeout) * time.Millisecond
+		case "retries":
+			retries, err := strconv.Atoi(last)
+			if err != nil || retries < 0 {
+				return hs, fmt.Errorf("invalid retries of home server '%v': '%v'", addr, last)
+			}
+			hs.Retry.Count = retries
+		case "retry-interval":
+			interval, err := strconv.Atoi(last)
+			if err != nil || interval < 0 {
+				return hs, fmt.Errorf("invalid retry-interval of home server '%v': '%v'", addr, last)
+			}
+			hs.Retry.Interval = time.Duration(interval) * time.Millisecond
+		case "retry-backoff":
+			backoff, err := strconv.ParseFloat(last, 64)
+			if err != nil || backoff < 1 {
+				return hs, fmt.Errorf("invalid retry-backoff of home server '%v': '%v'", addr, last)
+			}
+			hs.Retry.Backoff = backoff
+		case "direct":
+			direct, err := strconv.ParseBool(last)
+			if err != nil {
+				return hs, fmt.Errorf("invalid direct of home server '%v': '%v'", addr, last)
+			}
+			hs.Direct = direct
+		case "secret":
+			hs.Secrets = value
+		default:
+			return hs, fmt.Errorf("unknown option of home server '%v': '%v'", addr, name)
+		}
+	}
+
+	// applied once all the options are read, as they come in no particular order
+	if len(hs.Secrets) > 0 {
+		if values.Has("direct") && !hs.Direct {
+			return hs, fmt.Errorf("secret of home server '%v' cannot be given with direct=false", addr)
+		}
+		hs.Direct = true
+	}
+
+	return hs, nil
+}
+
+// ParseHomeServers parses a comma-separated list of home servers, see ParseHomeServer.
+func ParseHomeServers(s string, defaults Options) ([]HomeServer, error) {
+	var homeServers []HomeServer
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		hs, err := ParseHomeServer(entry, defaults)
+		if err != nil {
+			return nil, err
+		}
+		homeServers = append(homeServers, hs)
+	}
+	return homeServers, nil
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseHomeServer(t *testing.T) {
+	defaults := Options{Secrets: []string{"adminsecret"}, Timeout: 5 * time.Second, Retry: RetryPolicy{Count: 1, Backoff: 2}}
+	// the secrets of the status server are not copied, they are shared
+	inherited := Options{Timeout: 5 * time.Second, Retry: RetryPolicy{Count: 1, Backoff: 2}}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected HomeServer
+		wantErr  bool
+	}{
+		{
+			name:     "address only",
+			input:    "172.28.1.2:1812",
+			expected: HomeServer{Address: "172.28.1.2:1812", Options: inherited},
+		},
+		{
+			name:     "with type",
+			input:    "172.28.1.2:1812:auth",
+			expected: HomeServer{Address: "172.28.1.2:1812", Type: "auth", Options: inherited},
+		},
+		{
+			name:  "with timeout and retries",
+			input: "172.28.1.3:1813:acct?timeout=2000&retries=3&retry-interval=500&retry-backoff=1.5",
+			expected: HomeServer{Address: "172.28.1.3:1813", Type: "acct", Options: Options{
+				Timeout: 2 * time.Second,
+				Retry:   RetryPolicy{Count: 3, Interval: 500 * time.Millisecond, Backoff: 1.5},
+			}},
+		},
+		{
+			name:  "direct with secrets",
+			input: "172.28.1.4:18121?secret=new&secret=old",
+			expected: HomeServer{Address: "172.28.1.4:18121", Direct: true, Options: Options{
+				Secrets: []string{"new", "old"},
+				Timeout: 5 * time.Second,
+				Retry:   RetryPolicy{Count: 1, Backoff: 2},
+			}},
+		},
+		{
+			name:  "direct with secret",
+			input: "172.28.1.4:18121?direct=true&secret=new",
+			expected: HomeServer{Address: "172.28.1.4:18121", Direct: true, Options: Options{
+				Secrets: []string{"new"},
+				Timeout: 5 * time.Second,
+				Retry:   RetryPolicy{Count: 1, Backoff: 2},
+			}},
+		},
+		{
+			name:    "secret not direct",
+			input:   "172.28.1.4:18121?direct=false&secret=new",
+			wantErr: true,
+		},
+		{
+			name:    "unknown type",
+			input:   "172.28.1.2:1812:proxy",
+			wantErr: true,
+		},
+		{
+			name:    "invalid timeout",
+			input:   "172.28.1.2:1812?timeout=soon",
+			wantErr: true,
+		},
+		{
+			name:    "unknown option",
+			input:   "172.28.1.2:1812?weight=2",
+			wantErr: true,
+		},
+		{
+			name:    "missing port",
+			input:   "172.28.1.2",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHomeServer(tt.input, defaults)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error = %v, got = %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
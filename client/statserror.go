@@ -0,0 +1,43 @@
+package client
+
+import "strings"
+
+// Kinds of the errors FreeRADIUS answers in the FreeRADIUS-Stats-Error attribute.
+const (
+	StatsErrorNotAuth          = "not_auth"
+	StatsErrorNotAcct          = "not_acct"
+	StatsErrorNoSuchHomeServer = "no_such_home_server"
+	StatsErrorNoSuchClient     = "no_such_client"
+	StatsErrorOther            = "other"
+)
+
+// StatsErrorKinds are the kinds of stats errors, in the order they are matched.
+var StatsErrorKinds = []string{
+	StatsErrorNotAuth,
+	StatsErrorNotAcct,
+	StatsErrorNoSuchHomeServer,
+	StatsErrorNoSuchClient,
+	StatsErrorOther,
+}
+
+var statsErrorPatterns = map[string]string{
+	StatsErrorNotAuth:          "not auth",
+	StatsErrorNotAcct:          "not acct",
+	StatsErrorNoSuchHomeServer: "no such home server",
+	StatsErrorNoSuchClient:     "no such client",
+}
+
+// StatsErrorKind returns the kind of a stats error, StatsErrorOther when it is not a known one,
+// or an empty string when there is no error.
+func StatsErrorKind(message string) string {
+	if message == "" {
+		return ""
+	}
+	message = strings.ToLower(message)
+	for _, kind := range StatsErrorKinds {
+		if pattern, ok := statsErrorPatterns[kind]; ok && strings.Contains(message, pattern) {
+			return kind
+		}
+	}
+	return StatsErrorOther
+}
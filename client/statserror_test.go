@@ -0,0 +1,23 @@
+package client
+
+import "testing"
+
+func TestStatsErrorKind(t *testing.T) {
+	tests := []struct {
+		message  string
+		expected string
+	}{
+		{"", ""},
+		{"Home server is not auth", StatsErrorNotAuth},
+		{"Home server is not acct", StatsErrorNotAcct},
+		{"No such home server", StatsErrorNoSuchHomeServer},
+		{"No such client", StatsErrorNoSuchClient},
+		{"Something went wrong", StatsErrorOther},
+	}
+
+	for _, tt := range tests {
+		if got := StatsErrorKind(tt.message); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.message, tt.expected, got)
+		}
+	}
+}
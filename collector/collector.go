@@ -1,27 +1,128 @@
 package collector
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/bvantagelimited/freeradius_exporter/client"
+	"github.com/bvantagelimited/freeradius_exporter/events"
+	"github.com/bvantagelimited/freeradius_exporter/freeradius"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 // FreeRADIUSCollector type.
 type FreeRADIUSCollector struct {
-	client *client.FreeRADIUSClient
+	// client is replaced under mutex, and read without it by the readiness checks
+	client atomic.Pointer[client.FreeRADIUSClient]
 	// indicates if we could reach freeradius or not
-	up    *prometheus.Desc
-	mutex sync.Mutex
+	up               *prometheus.Desc
+	activeSecret     *prometheus.Desc
+	exchangeRetries  *prometheus.Desc
+	exchangeTimeouts *prometheus.Desc
+	scrapeDuration   *prometheus.Desc
+	lastScrapeError  *prometheus.Desc
+	lastSuccess      *prometheus.Desc
+	exchangeDuration *prometheus.HistogramVec
+	mutex            sync.Mutex
+
+	// state of the targets between scrapes, guarded by mutex
+	targets       map[string]*target
+	rawCounters   bool
+	stateHandlers []func(StateChange)
+	eventHandlers []func(events.Event)
+
+	// set when polling in the background, scrapes are then served from the cache
+	polling           bool
+	cached            []prometheus.Metric
+	last, previous    Snapshot
+	lastSuccessfulRun time.Time
+	cacheMutex        sync.RWMutex
+}
+
+// Snapshot holds the statistics of the targets fetched at a time.
+type Snapshot struct {
+	Time    time.Time
+	Targets []client.TargetStatistics
 }
 
 // NewFreeRADIUSCollector creates an FreeRADIUSCollector.
 func NewFreeRADIUSCollector(cl *client.FreeRADIUSClient) *FreeRADIUSCollector {
-	return &FreeRADIUSCollector{
-		client: cl,
+	f := &FreeRADIUSCollector{
+		targets: make(map[string]*target),
 		up: prometheus.NewDesc(
 			"freeradius_up", "Boolean gauge of 1 if freeradius was reachable, or 0 if not", []string{}, nil),
+		activeSecret: prometheus.NewDesc(
+			"freeradius_active_secret_index", "Index of the client secret the status server answered to last", []string{}, nil),
+		exchangeRetries: prometheus.NewDesc(
+			"freeradius_exchange_retries_total", "Total status requests sent again after a timeout", []string{"address"}, nil),
+		exchangeTimeouts: prometheus.NewDesc(
+			"freeradius_exchange_timeouts_total", "Total status requests without an answer in time", []string{"address"}, nil),
+		scrapeDuration: prometheus.NewDesc(
+			"freeradius_scrape_duration_seconds", "Duration of fetching the statistics of all targets", []string{}, nil),
+		lastScrapeError: prometheus.NewDesc(
+			"freeradius_last_scrape_error", "Boolean gauge of 1 if the last scrape of the target failed, or 0 if not", []string{"address"}, nil),
+		lastSuccess: prometheus.NewDesc(
+			"freeradius_last_successful_poll_timestamp_seconds", "Epoch timestamp of the last successful fetch of the statistics", []string{}, nil),
+		exchangeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "freeradius_exchange_duration_seconds",
+			Help:    "Duration of the status exchanges with the target, including retries",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"address"}),
+	}
+	f.client.Store(cl)
+	return f
+}
+
+// SetClient replaces the client the statistics are fetched with, once the running fetch is done. The targets
+// the new client no longer has are forgotten, along with their exchange durations.
+func (f *FreeRADIUSCollector) SetClient(cl *client.FreeRADIUSClient) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.client.Store(cl)
+
+	kept := make(map[string]bool)
+	for _, c := range cl.ExchangeCounters() {
+		kept[c.Address] = true
+	}
+	for address := range f.targets {
+		if !kept[address] {
+			delete(f.targets, address)
+			f.exchangeDuration.DeleteLabelValues(address)
+		}
+	}
+}
+
+// SetRawCounters sets whether the counters are also exported as the 32-bit values reported by the server.
+func (f *FreeRADIUSCollector) SetRawCounters(enabled bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.rawCounters = enabled
+}
+
+// OnStateChange adds a handler called with the state changes of the home servers. It is called while
+// fetching the statistics and must not block.
+func (f *FreeRADIUSCollector) OnStateChange(handler func(StateChange)) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.stateHandlers = append(f.stateHandlers, handler)
+}
+
+// OnEvent adds a handler called with the notable events seen while fetching the statistics: state changes,
+// restarts and HUPs, new stats errors, and exchanges starting or ending to fail. It must not block.
+func (f *FreeRADIUSCollector) OnEvent(handler func(events.Event)) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.eventHandlers = append(f.eventHandlers, handler)
+}
+
+func (f *FreeRADIUSCollector) emit(e events.Event) {
+	for _, handler := range f.eventHandlers {
+		handler(e)
 	}
 }
 
@@ -31,19 +132,223 @@ func (f *FreeRADIUSCollector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 // Collect fetches metrics from and sends them to the provided channel.
+// When polling in the background the metrics of the last poll are sent instead.
 func (f *FreeRADIUSCollector) Collect(ch chan<- prometheus.Metric) {
+	f.collect(context.Background(), ch)
+}
+
+// WithContext returns a collector fetching the metrics until ctx is done, sending those it got by then.
+func (f *FreeRADIUSCollector) WithContext(ctx context.Context) prometheus.Collector {
+	return &contextCollector{collector: f, ctx: ctx}
+}
+
+type contextCollector struct {
+	collector *FreeRADIUSCollector
+	ctx       context.Context
+}
+
+// Describe outputs metrics descriptions.
+func (c *contextCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.collector.Describe(ch)
+}
+
+// Collect fetches metrics from and sends them to the provided channel.
+func (c *contextCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collector.collect(c.ctx, ch)
+}
+
+func (f *FreeRADIUSCollector) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	f.cacheMutex.RLock()
+	polling := f.polling
+	f.cacheMutex.RUnlock()
+
+	if !polling {
+		f.run(ctx)
+	}
+
+	f.cacheMutex.RLock()
+	defer f.cacheMutex.RUnlock()
+
+	for _, m := range f.cached {
+		ch <- m
+	}
+	if !f.lastSuccessfulRun.IsZero() {
+		ch <- prometheus.MustNewConstMetric(f.lastSuccess, prometheus.GaugeValue, float64(f.lastSuccessfulRun.UnixNano())/1e9)
+	}
+	f.exchangeDuration.Collect(ch)
+}
+
+// Poll fetches the statistics every interval until ctx is done, and serves scrapes from the last result.
+func (f *FreeRADIUSCollector) Poll(ctx context.Context, interval time.Duration) {
+	f.cacheMutex.Lock()
+	f.polling = true
+	f.cacheMutex.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		f.run(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Ready returns whether an exchange with the status server succeeded. Until a scrape or a poll
+// succeeded, when not polling in the background, one status request is sent to find out.
+func (f *FreeRADIUSCollector) Ready(ctx context.Context) bool {
+	f.cacheMutex.RLock()
+	ready, polling := !f.lastSuccessfulRun.IsZero(), f.polling
+	f.cacheMutex.RUnlock()
+
+	if ready || polling {
+		return ready
+	}
+	return f.client.Load().Ping(ctx) == nil
+}
+
+// Stats returns the statistics of the targets fetched last, along with the time they were fetched at, zero
+// when they were never fetched. When not polling in the background, they are fetched first.
+func (f *FreeRADIUSCollector) Stats(ctx context.Context) ([]client.TargetStatistics, time.Time) {
+	f.cacheMutex.RLock()
+	polling := f.polling
+	f.cacheMutex.RUnlock()
+
+	if !polling {
+		f.run(ctx)
+	}
+
+	f.cacheMutex.RLock()
+	defer f.cacheMutex.RUnlock()
+	return f.last.Targets, f.last.Time
+}
+
+// Snapshots returns the last two fetches of the statistics, latest first, without fetching them.
+// A fetch that did not happen yet has a zero Time.
+func (f *FreeRADIUSCollector) Snapshots() (last, previous Snapshot) {
+	f.cacheMutex.RLock()
+	defer f.cacheMutex.RUnlock()
+	return f.last, f.previous
+}
+
+// run fetches the statistics and caches them along with the resulting metrics.
+func (f *FreeRADIUSCollector) run(ctx context.Context) {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
 
-	allStats, err := f.client.Stats()
+	start := time.Now()
+	metrics, stats, ok := f.scrape(ctx)
+
+	f.cacheMutex.Lock()
+	f.cached = metrics
+	f.previous, f.last = f.last, Snapshot{Time: start, Targets: stats}
+	if ok {
+		f.lastSuccessfulRun = time.Now()
+	}
+	f.cacheMutex.Unlock()
+}
+
+// scrape fetches the statistics and returns them as metrics, along with the statistics and whether freeradius
+// was reachable.
+func (f *FreeRADIUSCollector) scrape(ctx context.Context) ([]prometheus.Metric, []client.TargetStatistics, bool) {
+	var metrics []prometheus.Metric
+
+	cl := f.client.Load()
+	start := time.Now()
+	allStats, err := cl.StatsContext(ctx)
+	metrics = append(metrics, prometheus.MustNewConstMetric(f.scrapeDuration, prometheus.GaugeValue, time.Since(start).Seconds()))
+
+	// home servers without a start time of their own start over when the status server restarts
+	var statusStartTime time.Time
+	if len(allStats) > 0 && allStats[0].Err == nil {
+		statusStartTime = allStats[0].Statistics.Server.StartTime
+	}
+	for i, t := range allStats {
+		// home servers skipped because the status server could not be reached were not sent a request
+		skipped := errors.Is(t.Err, client.ErrStatusServerUnreachable)
+		if !skipped {
+			f.exchangeDuration.WithLabelValues(t.Address).Observe(t.Duration.Seconds())
+		}
+		metrics = append(metrics, prometheus.MustNewConstMetric(f.lastScrapeError, prometheus.GaugeValue, boolToFloat(t.Err != nil), t.Address))
+		state := f.target(t.Address)
+		if t.Err != nil {
+			if t.Err != err && !skipped {
+				log.Println(t.Err)
+			}
+			if !state.failing {
+				f.emit(events.Event{Time: start, Kind: events.KindExchangeFailure, Address: t.Address, Message: t.Err.Error()})
+			}
+			state.failing = true
+			continue
+		}
+		if state.failing {
+			f.emit(events.Event{Time: start, Kind: events.KindExchangeRecovered, Address: t.Address, Message: "answered again"})
+		}
+		state.failing = false
+
+		// stats errors are answered on every scrape, they are reported when they change only
+		if t.Statistics.Error != state.statsError {
+			if t.Statistics.Error != "" {
+				log.Printf("error form stats server (main or home server: %v): '%v'", t.Address, t.Statistics.Error)
+				f.emit(events.Event{Time: start, Kind: events.KindStatsError, Address: t.Address, Message: t.Statistics.Error})
+			} else {
+				log.Printf("stats error of %v cleared", t.Address)
+			}
+		}
+		state.statsError = t.Statistics.Error
+
+		lifecycle, change := lifecycleMetrics(t, state, start)
+		switch change {
+		case events.KindRestart:
+			f.emit(events.Event{Time: start, Kind: change, Address: t.Address, Message: fmt.Sprintf("started at %v", t.Statistics.Server.StartTime)})
+		case events.KindHUP:
+			f.emit(events.Event{Time: start, Kind: change, Address: t.Address, Message: fmt.Sprintf("HUP at %v", t.Statistics.Server.HUPTime)})
+		}
+		restarted := change == events.KindRestart
+		if i > 0 && !t.Has(freeradius.StartTime) {
+			restarted = state.statusServerRestarted(statusStartTime)
+		}
+		// statistics of the targets that answered are kept even when the status server did not
+		metrics = append(metrics, statsMetrics(t, state, restarted, f.rawCounters)...)
+		metrics = append(metrics, lifecycle...)
+		homeServer, stateChange := homeServerMetrics(t, state, start)
+		metrics = append(metrics, homeServer...)
+		if stateChange != nil {
+			log.Printf("home server %v changed state from %v to %v", stateChange.Address, stateChange.OldState, stateChange.NewState)
+			f.emit(events.Event{
+				Time:    start,
+				Kind:    events.KindStateChange,
+				Address: stateChange.Address,
+				Message: fmt.Sprintf("changed state from %v to %v", stateChange.OldState, stateChange.NewState),
+			})
+			for _, handler := range f.stateHandlers {
+				handler(*stateChange)
+			}
+		}
+	}
+
+	metrics = append(metrics, prometheus.MustNewConstMetric(f.activeSecret, prometheus.GaugeValue, float64(cl.ActiveSecretIndex())))
+	for _, c := range cl.ExchangeCounters() {
+		metrics = append(metrics, prometheus.MustNewConstMetric(f.exchangeRetries, prometheus.CounterValue, float64(c.Retries), c.Address))
+		metrics = append(metrics, prometheus.MustNewConstMetric(f.exchangeTimeouts, prometheus.CounterValue, float64(c.Timeouts), c.Address))
+	}
+
 	if err != nil {
 		log.Println(err)
-		ch <- prometheus.MustNewConstMetric(f.up, prometheus.GaugeValue, float64(0))
-		return
+		metrics = append(metrics, prometheus.MustNewConstMetric(f.up, prometheus.GaugeValue, float64(0)))
+		return metrics, allStats, false
 	}
-	ch <- prometheus.MustNewConstMetric(f.up, prometheus.GaugeValue, float64(1))
+	metrics = append(metrics, prometheus.MustNewConstMetric(f.up, prometheus.GaugeValue, float64(1)))
+
+	return metrics, allStats, true
+}
 
-	for _, stats := range allStats {
-		ch <- stats
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
 	}
+	return 0
 }
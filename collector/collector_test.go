@@ -0,0 +1,487 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bvantagelimited/freeradius_exporter/client"
+	"github.com/bvantagelimited/freeradius_exporter/events"
+	"github.com/bvantagelimited/freeradius_exporter/freeradius"
+	"github.com/bvantagelimited/freeradius_exporter/internal/radiustest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"layeh.com/radius"
+)
+
+// newTestCollector starts a status server answering with the attributes set by respond,
+// and returns a collector of it along with its address.
+func newTestCollector(t *testing.T, respond func(response *radius.Packet)) (*FreeRADIUSCollector, string) {
+	t.Helper()
+
+	addr := radiustest.NewServer(t, "secret", func(w radius.ResponseWriter, r *radius.Request) {
+		response := r.Response(radius.CodeAccessAccept)
+		respond(response)
+		w.Write(response)
+	})
+	cl, err := client.NewFreeRADIUSClient(addr, nil, client.Options{
+		Secrets: []string{"secret"},
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewFreeRADIUSCollector(cl), addr
+}
+
+func TestCollect(t *testing.T) {
+	c, addr := newTestCollector(t, func(response *radius.Packet) {
+		freeradius.SetValue(response, freeradius.TotalAccessRequests, radius.NewInteger(42))
+		freeradius.SetValue(response, freeradius.QueueLenAuth, radius.NewInteger(3))
+	})
+
+	expected := `
+# HELP freeradius_queue_len_auth Auth queue length
+# TYPE freeradius_queue_len_auth gauge
+freeradius_queue_len_auth{address="` + addr + `"} 3
+# HELP freeradius_total_access_requests Total access requests
+# TYPE freeradius_total_access_requests counter
+freeradius_total_access_requests{address="` + addr + `"} 42
+# HELP freeradius_up Boolean gauge of 1 if freeradius was reachable, or 0 if not
+# TYPE freeradius_up gauge
+freeradius_up 1
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"freeradius_up", "freeradius_total_access_requests", "freeradius_queue_len_auth", "freeradius_total_access_accepts")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// gather collects the metrics of c by name.
+func gather(t *testing.T, c prometheus.Collector) map[string]*dto.MetricFamily {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]*dto.MetricFamily)
+	for _, mf := range families {
+		byName[mf.GetName()] = mf
+	}
+	return byName
+}
+
+func TestScrapeMetrics(t *testing.T) {
+	c, addr := newTestCollector(t, func(response *radius.Packet) {})
+	opts := client.Options{Secrets: []string{"secret"}, Timeout: 100 * time.Millisecond}
+	cl, err := client.NewFreeRADIUSClient(addr, []client.HomeServer{{Address: "127.0.0.1:1", Direct: true, Options: opts}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetClient(cl)
+
+	expected := `
+# HELP freeradius_last_scrape_error Boolean gauge of 1 if the last scrape of the target failed, or 0 if not
+# TYPE freeradius_last_scrape_error gauge
+freeradius_last_scrape_error{address="127.0.0.1:1"} 1
+freeradius_last_scrape_error{address="` + addr + `"} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "freeradius_last_scrape_error"); err != nil {
+		t.Error(err)
+	}
+
+	families := gather(t, c)
+	if d := families["freeradius_scrape_duration_seconds"].GetMetric()[0].GetGauge().GetValue(); d <= 0 {
+		t.Errorf("expected a positive scrape duration, got %v", d)
+	}
+	// one observation per target and scrape, two scrapes so far
+	histograms := families["freeradius_exchange_duration_seconds"].GetMetric()
+	if len(histograms) != 2 {
+		t.Fatalf("expected an exchange duration histogram per target, got %d", len(histograms))
+	}
+	for _, m := range histograms {
+		if count := m.GetHistogram().GetSampleCount(); count != 2 {
+			t.Errorf("expected 2 exchange durations of %v, got %d", m.GetLabel()[0].GetValue(), count)
+		}
+	}
+}
+
+func TestSetClientRemovedTarget(t *testing.T) {
+	c, addr := newTestCollector(t, func(response *radius.Packet) {})
+	opts := client.Options{Secrets: []string{"secret"}, Timeout: 100 * time.Millisecond}
+	withHomeServer, err := client.NewFreeRADIUSClient(addr, []client.HomeServer{{Address: "127.0.0.1:1", Direct: true, Options: opts}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetClient(withHomeServer)
+	gather(t, c)
+
+	// the home server is removed on reload
+	withoutHomeServer, err := client.NewFreeRADIUSClient(addr, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetClient(withoutHomeServer)
+
+	if _, ok := c.targets["127.0.0.1:1"]; ok || len(c.targets) != 1 {
+		t.Errorf("expected only the status server to be remembered, got %v", c.targets)
+	}
+	if n := testutil.CollectAndCount(c.exchangeDuration); n != 1 {
+		t.Errorf("expected the exchange durations of the status server only, got %d histograms", n)
+	}
+}
+
+func TestPoll(t *testing.T) {
+	var requests atomic.Uint32
+	c, addr := newTestCollector(t, func(response *radius.Packet) {
+		freeradius.SetValue(response, freeradius.TotalAccessRequests, radius.NewInteger(requests.Add(1)))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	start := time.Now()
+	go func() {
+		c.Poll(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	for deadline := time.Now().Add(time.Second); requests.Load() < 3 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	polled := requests.Load()
+	if polled < 3 {
+		t.Fatalf("expected at least 3 polls, got %d", polled)
+	}
+
+	// scrapes are served from the last poll without a request
+	families := gather(t, c)
+	if got := requests.Load(); got != polled {
+		t.Errorf("expected no request on scrape, got %d more", got-polled)
+	}
+	expected := fmt.Sprintf(`
+# HELP freeradius_total_access_requests Total access requests
+# TYPE freeradius_total_access_requests counter
+freeradius_total_access_requests{address="%v"} %d
+`, addr, polled)
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "freeradius_total_access_requests"); err != nil {
+		t.Error(err)
+	}
+
+	last := families["freeradius_last_successful_poll_timestamp_seconds"].GetMetric()
+	if len(last) != 1 {
+		t.Fatalf("expected the last successful poll timestamp, got %v", last)
+	}
+	if ts := last[0].GetGauge().GetValue(); ts < float64(start.UnixNano())/1e9 || ts > float64(time.Now().UnixNano())/1e9 {
+		t.Errorf("expected the last successful poll timestamp during the test, got %v", ts)
+	}
+}
+
+func TestPollUnreachable(t *testing.T) {
+	cl, err := client.NewFreeRADIUSClient("127.0.0.1:1", nil, client.Options{Secrets: []string{"secret"}, Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewFreeRADIUSCollector(cl)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Poll(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	time.Sleep(30 * time.Millisecond)
+	cancel()
+	<-done
+
+	families := gather(t, c)
+	if _, ok := families["freeradius_last_successful_poll_timestamp_seconds"]; ok {
+		t.Error("expected no last successful poll timestamp without an answer")
+	}
+	if up := families["freeradius_up"].GetMetric()[0].GetGauge().GetValue(); up != 0 {
+		t.Errorf("expected freeradius_up 0, got %v", up)
+	}
+}
+
+func TestRestartAndHUP(t *testing.T) {
+	var mutex sync.Mutex
+	startTime := time.Unix(1700000000, 0)
+	hupTime := startTime
+	c, addr := newTestCollector(t, func(response *radius.Packet) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		start, _ := radius.NewDate(startTime)
+		hup, _ := radius.NewDate(hupTime)
+		freeradius.SetValue(response, freeradius.StartTime, start)
+		freeradius.SetValue(response, freeradius.HUPTime, hup)
+	})
+	var kinds []string
+	c.OnEvent(func(e events.Event) {
+		kinds = append(kinds, e.Kind)
+	})
+
+	scrape := func(restarts, hups int) {
+		t.Helper()
+		expected := fmt.Sprintf(`
+# HELP freeradius_hups_total Total HUPs of the server seen by the exporter, from changes of its HUP time
+# TYPE freeradius_hups_total counter
+freeradius_hups_total{address="%[1]v"} %[3]v
+# HELP freeradius_restarts_total Total restarts of the server seen by the exporter, from changes of its start time
+# TYPE freeradius_restarts_total counter
+freeradius_restarts_total{address="%[1]v"} %[2]v
+`, addr, restarts, hups)
+		if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "freeradius_restarts_total", "freeradius_hups_total"); err != nil {
+			t.Error(err)
+		}
+	}
+
+	scrape(0, 0)
+
+	mutex.Lock()
+	hupTime = startTime.Add(time.Hour)
+	mutex.Unlock()
+	scrape(0, 1)
+
+	mutex.Lock()
+	startTime = startTime.Add(2 * time.Hour)
+	hupTime = startTime
+	mutex.Unlock()
+	scrape(1, 1)
+
+	if expected := []string{events.KindHUP, events.KindRestart}; !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("expected events %v, got %v", expected, kinds)
+	}
+}
+
+func TestCounterWrap(t *testing.T) {
+	var mutex sync.Mutex
+	startTime := time.Unix(1700000000, 0)
+	requests := uint32(math.MaxUint32 - 10)
+	c, addr := newTestCollector(t, func(response *radius.Packet) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		start, _ := radius.NewDate(startTime)
+		freeradius.SetValue(response, freeradius.StartTime, start)
+		freeradius.SetValue(response, freeradius.TotalAccessRequests, radius.NewInteger(requests))
+	})
+	c.SetRawCounters(true)
+
+	scrape := func(total uint64, raw uint32) {
+		t.Helper()
+		expected := fmt.Sprintf(`
+# HELP freeradius_total_access_requests Total access requests
+# TYPE freeradius_total_access_requests counter
+freeradius_total_access_requests{address="%[1]v"} %[2]v
+# HELP freeradius_total_access_requests_raw Total access requests, as the 32-bit value reported by the server
+# TYPE freeradius_total_access_requests_raw counter
+freeradius_total_access_requests_raw{address="%[1]v"} %[3]v
+`, addr, total, raw)
+		if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "freeradius_total_access_requests", "freeradius_total_access_requests_raw"); err != nil {
+			t.Error(err)
+		}
+	}
+
+	scrape(math.MaxUint32-10, math.MaxUint32-10)
+
+	mutex.Lock()
+	requests = 5
+	mutex.Unlock()
+	scrape(1<<32+5, 5)
+
+	mutex.Lock()
+	startTime = startTime.Add(time.Hour)
+	requests = 3
+	mutex.Unlock()
+	scrape(3, 3)
+}
+
+func TestHomeServerCountersStatusServerRestart(t *testing.T) {
+	var mutex sync.Mutex
+	startTime := time.Unix(1700000000, 0)
+	requests := uint32(math.MaxUint32 - 10)
+	homeFails := false
+	addr := radiustest.NewServer(t, "secret", func(w radius.ResponseWriter, r *radius.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		response := r.Response(radius.CodeAccessAccept)
+		// the home server is asked for with its port, it has no start time of its own
+		if port, _ := freeradius.GetInt(r.Packet, freeradius.ServerPort); port == 1812 {
+			if homeFails {
+				return
+			}
+			freeradius.SetValue(response, freeradius.TotalAccessRequests, radius.NewInteger(requests))
+		} else {
+			start, _ := radius.NewDate(startTime)
+			freeradius.SetValue(response, freeradius.StartTime, start)
+		}
+		w.Write(response)
+	})
+	opts := client.Options{Secrets: []string{"secret"}, Timeout: 100 * time.Millisecond}
+	cl, err := client.NewFreeRADIUSClient(addr, []client.HomeServer{{Address: "10.0.0.1:1812", Type: "auth", Options: opts}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewFreeRADIUSCollector(cl)
+
+	scrape := func(total uint64) {
+		t.Helper()
+		expected := fmt.Sprintf(`
+# HELP freeradius_total_access_requests Total access requests
+# TYPE freeradius_total_access_requests counter
+freeradius_total_access_requests{address="10.0.0.1:1812"} %v
+`, total)
+		if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "freeradius_total_access_requests"); err != nil {
+			t.Error(err)
+		}
+	}
+
+	scrape(math.MaxUint32 - 10)
+
+	// the status server restarts while the home server does not answer
+	mutex.Lock()
+	startTime = startTime.Add(time.Hour)
+	homeFails = true
+	mutex.Unlock()
+	gather(t, c)
+
+	// the smaller value is a restart, not a wrap
+	mutex.Lock()
+	homeFails = false
+	requests = 3
+	mutex.Unlock()
+	scrape(3)
+}
+
+func TestHomeServerState(t *testing.T) {
+	var mutex sync.Mutex
+	state := uint32(0)
+	c, addr := newTestCollector(t, func(response *radius.Packet) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		freeradius.SetValue(response, freeradius.ServerState, radius.NewInteger(state))
+	})
+	var changes []StateChange
+	c.OnStateChange(func(change StateChange) {
+		changes = append(changes, change)
+	})
+
+	scrape := func(current string, changes int) {
+		t.Helper()
+		var expected strings.Builder
+		expected.WriteString(`
+# HELP freeradius_home_server_state Boolean gauge of 1 for the current state of the home server
+# TYPE freeradius_home_server_state gauge
+`)
+		for _, name := range serverStates {
+			fmt.Fprintf(&expected, "freeradius_home_server_state{address=%q,state=%q} %v\n", addr, name, boolToFloat(name == current))
+		}
+		fmt.Fprintf(&expected, `# HELP freeradius_home_server_state_changes_total Total state changes of the home server seen by the exporter
+# TYPE freeradius_home_server_state_changes_total counter
+freeradius_home_server_state_changes_total{address=%q} %v
+`, addr, changes)
+		err := testutil.CollectAndCompare(c, strings.NewReader(expected.String()),
+			"freeradius_home_server_state", "freeradius_home_server_state_changes_total")
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	scrape("alive", 0)
+	scrape("alive", 0)
+
+	mutex.Lock()
+	state = 2
+	mutex.Unlock()
+	scrape("dead", 1)
+
+	if len(changes) != 1 || changes[0].OldState != "alive" || changes[0].NewState != "dead" || changes[0].Address != addr {
+		t.Errorf("expected a change from alive to dead, got %+v", changes)
+	}
+}
+
+func TestStatsError(t *testing.T) {
+	c, addr := newTestCollector(t, func(response *radius.Packet) {
+		value, _ := radius.NewString("Home server is not auth")
+		freeradius.SetValue(response, freeradius.StatsError, value)
+	})
+
+	var expected strings.Builder
+	expected.WriteString(`
+# HELP freeradius_stats_error Boolean gauge of 1 if the server answered with a stats error of the kind, or 0 if not
+# TYPE freeradius_stats_error gauge
+`)
+	for _, kind := range client.StatsErrorKinds {
+		fmt.Fprintf(&expected, "freeradius_stats_error{address=%q,kind=%q} %v\n", addr, kind, boolToFloat(kind == client.StatsErrorNotAuth))
+	}
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected.String()), "freeradius_stats_error"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReady(t *testing.T) {
+	c, _ := newTestCollector(t, func(response *radius.Packet) {})
+	if !c.Ready(context.Background()) {
+		t.Error("expected collector to be ready once the status server answered")
+	}
+	if last, _ := c.Snapshots(); !last.Time.IsZero() {
+		t.Errorf("expected the readiness check not to fetch the statistics, got %+v", last)
+	}
+
+	silent := radiustest.NewServer(t, "secret", radiustest.Silent)
+	cl, err := client.NewFreeRADIUSClient(silent, nil, client.Options{
+		Secrets: []string{"secret"},
+		Timeout: 100 * time.Millisecond,
+		Retry:   client.RetryPolicy{Count: 5, Interval: time.Second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if NewFreeRADIUSCollector(cl).Ready(context.Background()) {
+		t.Error("expected collector not to be ready without an answer")
+	}
+	// a single status request is sent, without retries
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the readiness check to take at most the timeout, took %v", elapsed)
+	}
+	if counters := cl.ExchangeCounters(); counters[0].Retries != 0 || counters[0].Timeouts != 0 {
+		t.Errorf("expected the readiness check not to count exchanges, got %+v", counters)
+	}
+}
+
+func TestReadySetClient(t *testing.T) {
+	addr := radiustest.NewServer(t, "secret", radiustest.Accept)
+	newClient := func() *client.FreeRADIUSClient {
+		cl, err := client.NewFreeRADIUSClient(addr, nil, client.Options{Secrets: []string{"secret"}, Timeout: time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cl
+	}
+	c := NewFreeRADIUSCollector(newClient())
+
+	// the client is replaced on reload while the readiness is checked, run with -race
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			c.SetClient(newClient())
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		if !c.Ready(context.Background()) {
+			t.Error("expected collector to be ready")
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,81 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/bvantagelimited/freeradius_exporter/client"
+	"github.com/bvantagelimited/freeradius_exporter/freeradius"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// serverStates are the names of the home server states, indexed by their value.
+var serverStates = []string{"alive", "zombie", "dead", "idle"}
+
+var (
+	homeServerStateDesc        = prometheus.NewDesc("freeradius_home_server_state", "Boolean gauge of 1 for the current state of the home server", []string{"address", "state"}, nil)
+	homeServerInfoDesc         = prometheus.NewDesc("freeradius_home_server_info", "Home server with its type, as given or detected, with a const value of 1", []string{"address", "type"}, nil)
+	homeServerStateChangesDesc = newDesc("freeradius_home_server_state_changes_total", "Total state changes of the home server seen by the exporter")
+	sinceDeathDesc             = newDesc("freeradius_home_server_seconds_since_death", "Seconds since the home server was last marked as 'dead'")
+	sinceLifeDesc              = newDesc("freeradius_home_server_seconds_since_life", "Seconds since the home server was last marked as 'alive'")
+)
+
+// StateChange is a transition of the state of a home server.
+type StateChange struct {
+	Address     string    `json:"address"`
+	OldState    string    `json:"old_state"`
+	NewState    string    `json:"new_state"`
+	TimeOfDeath time.Time `json:"time_of_death"`
+	TimeOfLife  time.Time `json:"time_of_life"`
+	Time        time.Time `json:"time"`
+}
+
+// StateName returns the name of a home server state.
+func StateName(state uint32) string {
+	if int(state) < len(serverStates) {
+		return serverStates[state]
+	}
+	return "unknown"
+}
+
+// homeServerMetrics returns the state of the target as one gauge per state, along with its state changes
+// counted with the previous state remembered in state, and the time passed since it was marked dead or alive.
+// The state change is returned too, or nil when the state did not change.
+func homeServerMetrics(t client.TargetStatistics, state *target, now time.Time) ([]prometheus.Metric, *StateChange) {
+	var metrics []prometheus.Metric
+	var change *StateChange
+	s := t.Statistics.Server
+
+	if t.Type != "" {
+		metrics = append(metrics, prometheus.MustNewConstMetric(homeServerInfoDesc, prometheus.GaugeValue, 1, t.Address, t.Type))
+	}
+
+	if t.Has(freeradius.ServerState) {
+		if state.hasState && s.State != state.state {
+			state.stateChanges++
+			change = &StateChange{
+				Address:     t.Address,
+				OldState:    StateName(state.state),
+				NewState:    StateName(s.State),
+				TimeOfDeath: s.TimeOfDeath,
+				TimeOfLife:  s.TimeOfLife,
+				Time:        now,
+			}
+		}
+		state.state = s.State
+		state.hasState = true
+
+		for i, name := range serverStates {
+			metrics = append(metrics, prometheus.MustNewConstMetric(homeServerStateDesc, prometheus.GaugeValue, boolToFloat(s.State == uint32(i)), t.Address, name))
+		}
+		metrics = append(metrics, prometheus.MustNewConstMetric(homeServerStateChangesDesc, prometheus.CounterValue, float64(state.stateChanges), t.Address))
+	}
+
+	if t.Has(freeradius.ServerTimeOfDeath) && !s.TimeOfDeath.IsZero() {
+		metrics = append(metrics, prometheus.MustNewConstMetric(sinceDeathDesc, prometheus.GaugeValue, now.Sub(s.TimeOfDeath).Seconds(), t.Address))
+	}
+	if t.Has(freeradius.ServerTimeOfLife) && !s.TimeOfLife.IsZero() {
+		metrics = append(metrics, prometheus.MustNewConstMetric(sinceLifeDesc, prometheus.GaugeValue, now.Sub(s.TimeOfLife).Seconds(), t.Address))
+	}
+
+	return metrics, change
+}
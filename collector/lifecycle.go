@@ -0,0 +1,123 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/bvantagelimited/freeradius_exporter/client"
+	"github.com/bvantagelimited/freeradius_exporter/events"
+	"github.com/bvantagelimited/freeradius_exporter/freeradius"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	restartsDesc            = newDesc("freeradius_restarts_total", "Total restarts of the server seen by the exporter, from changes of its start time")
+	hupsDesc                = newDesc("freeradius_hups_total", "Total HUPs of the server seen by the exporter, from changes of its HUP time")
+	uptimeDesc              = newDesc("freeradius_uptime_seconds", "Seconds since the server was started")
+	sinceLastPacketRecvDesc = newDesc("freeradius_seconds_since_last_packet_recv", "Seconds since the last packet was received")
+	sinceLastPacketSentDesc = newDesc("freeradius_seconds_since_last_packet_sent", "Seconds since the last packet was sent")
+)
+
+// target holds what the collector remembers of a target between scrapes.
+type target struct {
+	startTime time.Time
+	hupTime   time.Time
+	restarts  uint64
+	hups      uint64
+	counters  map[byte]*wrappingCounter
+	// start time of the status server the counters were last updated under, for the home servers
+	// without a start time of their own
+	statusStartTime time.Time
+
+	// home server state
+	state        uint32
+	hasState     bool
+	stateChanges uint64
+
+	// last problems, reported as events when they change
+	failing    bool
+	statsError string
+}
+
+// wrappingCounter extends a 32-bit counter of the server to 64 bits by counting its wraps.
+type wrappingCounter struct {
+	raw   uint32
+	wraps uint64
+}
+
+func (c *wrappingCounter) value() uint64 {
+	return c.wraps<<32 + uint64(c.raw)
+}
+
+// counter updates the counter of attr with raw. A value smaller than the previous one is a wrap,
+// unless the server restarted, which starts the counter over.
+func (t *target) counter(attr byte, raw uint32, restarted bool) *wrappingCounter {
+	c, ok := t.counters[attr]
+	switch {
+	case !ok:
+		c = &wrappingCounter{}
+		t.counters[attr] = c
+	case restarted:
+		c.wraps = 0
+	case raw < c.raw:
+		c.wraps++
+	}
+	c.raw = raw
+	return c
+}
+
+// statusServerRestarted returns whether the status server started at startTime, zero when not known, since the
+// counters of the home server were last updated, including while the home server did not answer.
+func (t *target) statusServerRestarted(startTime time.Time) bool {
+	if startTime.IsZero() {
+		return false
+	}
+	restarted := !t.statusStartTime.IsZero() && !t.statusStartTime.Equal(startTime)
+	t.statusStartTime = startTime
+	return restarted
+}
+
+// target returns the remembered state of the target at address.
+func (f *FreeRADIUSCollector) target(address string) *target {
+	t, ok := f.targets[address]
+	if !ok {
+		t = &target{counters: make(map[byte]*wrappingCounter)}
+		f.targets[address] = t
+	}
+	return t
+}
+
+// lifecycleMetrics detects restarts and HUPs of the target from its start and HUP times, and returns them
+// along with the time passed since the start and the last packets. The kind of event detected is returned
+// too, events.KindRestart or events.KindHUP, or an empty string.
+func lifecycleMetrics(t client.TargetStatistics, state *target, now time.Time) ([]prometheus.Metric, string) {
+	var metrics []prometheus.Metric
+	var change string
+	s := t.Statistics.Server
+
+	if t.Has(freeradius.StartTime) {
+		if !state.startTime.IsZero() && !s.StartTime.Equal(state.startTime) {
+			state.restarts++
+			change = events.KindRestart
+		} else if t.Has(freeradius.HUPTime) && !state.hupTime.IsZero() && !s.HUPTime.Equal(state.hupTime) {
+			state.hups++
+			change = events.KindHUP
+		}
+		state.startTime = s.StartTime
+		state.hupTime = s.HUPTime
+
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(restartsDesc, prometheus.CounterValue, float64(state.restarts), t.Address),
+			prometheus.MustNewConstMetric(hupsDesc, prometheus.CounterValue, float64(state.hups), t.Address),
+			prometheus.MustNewConstMetric(uptimeDesc, prometheus.GaugeValue, now.Sub(s.StartTime).Seconds(), t.Address),
+		)
+	}
+
+	if t.Has(freeradius.LastPacketRecv) && !s.LastPacketRecv.IsZero() {
+		metrics = append(metrics, prometheus.MustNewConstMetric(sinceLastPacketRecvDesc, prometheus.GaugeValue, now.Sub(s.LastPacketRecv).Seconds(), t.Address))
+	}
+	if t.Has(freeradius.LastPacketSent) && !s.LastPacketSent.IsZero() {
+		metrics = append(metrics, prometheus.MustNewConstMetric(sinceLastPacketSentDesc, prometheus.GaugeValue, now.Sub(s.LastPacketSent).Seconds(), t.Address))
+	}
+
+	return metrics, change
+}
@@ -0,0 +1,123 @@
+package collector
+
+import (
+	"github.com/bvantagelimited/freeradius_exporter/client"
+	"github.com/bvantagelimited/freeradius_exporter/freeradius"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// statMetric maps a FreeRADIUS statistics attribute to its metric.
+type statMetric struct {
+	attr      byte
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(s *client.Statistics) float64
+	// raw is the desc of the 32-bit value as reported, set for counters only
+	raw *prometheus.Desc
+}
+
+func newDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(name, help, []string{"address"}, nil)
+}
+
+func gauge(attr byte, name, help string, value func(s *client.Statistics) float64) statMetric {
+	return statMetric{attr: attr, desc: newDesc(name, help), valueType: prometheus.GaugeValue, value: value}
+}
+
+// counter creates the metric of a 32-bit counter, which is exported as a monotonic 64-bit counter carried
+// over wraps, with the value as reported by the server optionally available as name_raw.
+func counter(attr byte, name, help string, value func(s *client.Statistics) float64) statMetric {
+	return statMetric{
+		attr:      attr,
+		desc:      newDesc(name, help),
+		valueType: prometheus.CounterValue,
+		value:     value,
+		raw:       newDesc(name+"_raw", help+", as the 32-bit value reported by the server"),
+	}
+}
+
+var statsError = prometheus.NewDesc("freeradius_stats_error", "Boolean gauge of 1 if the server answered with a stats error of the kind, or 0 if not", []string{"address", "kind"}, nil)
+
+var statMetrics = []statMetric{
+	gauge(freeradius.LastPacketRecv, "freeradius_last_packet_recv", "Epoch timestamp when the last packet was received", func(s *client.Statistics) float64 { return float64(s.Server.LastPacketRecv.Unix()) }),
+	gauge(freeradius.LastPacketSent, "freeradius_last_packet_sent", "Epoch timestamp when the last packet was sent", func(s *client.Statistics) float64 { return float64(s.Server.LastPacketSent.Unix()) }),
+	gauge(freeradius.HUPTime, "freeradius_hup_time", "Epoch timestamp when the server hang up (If start == hup, it hasn't been hup'd yet)", func(s *client.Statistics) float64 { return float64(s.Server.HUPTime.Unix()) }),
+	gauge(freeradius.StartTime, "freeradius_start_time", "Epoch timestamp when the server was started", func(s *client.Statistics) float64 { return float64(s.Server.StartTime.Unix()) }),
+	gauge(freeradius.ServerState, "freeradius_state", "State of the server. Alive = 0; Zombie = 1; Dead = 2; Idle = 3", func(s *client.Statistics) float64 { return float64(s.Server.State) }),
+	gauge(freeradius.ServerTimeOfDeath, "freeradius_time_of_death", "Epoch timestamp when a home server is marked as 'dead'", func(s *client.Statistics) float64 { return float64(s.Server.TimeOfDeath.Unix()) }),
+	gauge(freeradius.ServerTimeOfLife, "freeradius_time_of_life", "Epoch timestamp when a home server is marked as 'alive'", func(s *client.Statistics) float64 { return float64(s.Server.TimeOfLife.Unix()) }),
+	gauge(freeradius.EmaWindow, "freeradius_ema_window", "Exponential moving average of home server response time", func(s *client.Statistics) float64 { return float64(s.Server.EmaWindow) }),
+	gauge(freeradius.EmaUsecWindow1, "freeradius_ema_window1_usec", "Window-1 is the average is calculated over 'window' packets", func(s *client.Statistics) float64 { return float64(s.Server.EmaUsecWindow1) }),
+	gauge(freeradius.EmaUsecWindow10, "freeradius_ema_window10_usec", "Window-10 is the average is calculated over '10 * window' packets", func(s *client.Statistics) float64 { return float64(s.Server.EmaUsecWindow10) }),
+	gauge(freeradius.ServerOutstandingRequests, "freeradius_outstanding_requests", "Outstanding requests", func(s *client.Statistics) float64 { return float64(s.Server.OutstandingRequests) }),
+	gauge(freeradius.QueuePPSIn, "freeradius_queue_pps_in", "Queue PPS in", func(s *client.Statistics) float64 { return float64(s.Server.QueuePPSIn) }),
+	gauge(freeradius.QueuePPSOut, "freeradius_queue_pps_out", "Queue PPS out", func(s *client.Statistics) float64 { return float64(s.Server.QueuePPSOut) }),
+	gauge(freeradius.QueueUsePercentage, "freeradius_queue_use_percentage", "Queue usage percentage", func(s *client.Statistics) float64 { return float64(s.Server.QueueUsePercentage) }),
+	counter(freeradius.TotalAccessRequests, "freeradius_total_access_requests", "Total access requests", func(s *client.Statistics) float64 { return float64(s.Access.Requests) }),
+	counter(freeradius.TotalAccessAccepts, "freeradius_total_access_accepts", "Total access accepts", func(s *client.Statistics) float64 { return float64(s.Access.Accepts) }),
+	counter(freeradius.TotalAccessRejects, "freeradius_total_access_rejects", "Total access rejects", func(s *client.Statistics) float64 { return float64(s.Access.Rejects) }),
+	counter(freeradius.TotalAccessChallenges, "freeradius_total_access_challenges", "Total access challenges", func(s *client.Statistics) float64 { return float64(s.Access.Challenges) }),
+	counter(freeradius.TotalAuthResponses, "freeradius_total_auth_responses", "Total auth responses", func(s *client.Statistics) float64 { return float64(s.Auth.Responses) }),
+	counter(freeradius.TotalAuthDuplicateRequests, "freeradius_total_auth_duplicate_requests", "Total auth duplicate requests", func(s *client.Statistics) float64 { return float64(s.Auth.DuplicateRequests) }),
+	counter(freeradius.TotalAuthMalformedRequests, "freeradius_total_auth_malformed_requests", "Total auth malformed requests", func(s *client.Statistics) float64 { return float64(s.Auth.MalformedRequests) }),
+	counter(freeradius.TotalAuthInvalidRequests, "freeradius_total_auth_invalid_requests", "Total auth invalid requests", func(s *client.Statistics) float64 { return float64(s.Auth.InvalidRequests) }),
+	counter(freeradius.TotalAuthDroppedRequests, "freeradius_total_auth_dropped_requests", "Total auth dropped requests", func(s *client.Statistics) float64 { return float64(s.Auth.DroppedRequests) }),
+	counter(freeradius.TotalAuthUnknownTypes, "freeradius_total_auth_unknown_types", "Total auth unknown types", func(s *client.Statistics) float64 { return float64(s.Auth.UnknownTypes) }),
+	counter(freeradius.TotalProxyAccessRequests, "freeradius_total_proxy_access_requests", "Total proxy access requests", func(s *client.Statistics) float64 { return float64(s.ProxyAccess.Requests) }),
+	counter(freeradius.TotalProxyAccessAccepts, "freeradius_total_proxy_access_accepts", "Total proxy access accepts", func(s *client.Statistics) float64 { return float64(s.ProxyAccess.Accepts) }),
+	counter(freeradius.TotalProxyAccessRejects, "freeradius_total_proxy_access_rejects", "Total proxy access rejects", func(s *client.Statistics) float64 { return float64(s.ProxyAccess.Rejects) }),
+	counter(freeradius.TotalProxyAccessChallenges, "freeradius_total_proxy_access_challenges", "Total proxy access challenges", func(s *client.Statistics) float64 { return float64(s.ProxyAccess.Challenges) }),
+	counter(freeradius.TotalProxyAuthResponses, "freeradius_total_proxy_auth_responses", "Total proxy auth responses", func(s *client.Statistics) float64 { return float64(s.ProxyAuth.Responses) }),
+	counter(freeradius.TotalProxyAuthDuplicateRequests, "freeradius_total_proxy_auth_duplicate_requests", "Total proxy auth duplicate requests", func(s *client.Statistics) float64 { return float64(s.ProxyAuth.DuplicateRequests) }),
+	counter(freeradius.TotalProxyAuthMalformedRequests, "freeradius_total_proxy_auth_malformed_requests", "Total proxy auth malformed requests", func(s *client.Statistics) float64 { return float64(s.ProxyAuth.MalformedRequests) }),
+	counter(freeradius.TotalProxyAuthInvalidRequests, "freeradius_total_proxy_auth_invalid_requests", "Total proxy auth invalid requests", func(s *client.Statistics) float64 { return float64(s.ProxyAuth.InvalidRequests) }),
+	counter(freeradius.TotalProxyAuthDroppedRequests, "freeradius_total_proxy_auth_dropped_requests", "Total proxy auth dropped requests", func(s *client.Statistics) float64 { return float64(s.ProxyAuth.DroppedRequests) }),
+	counter(freeradius.TotalProxyAuthUnknownTypes, "freeradius_total_proxy_auth_unknown_types", "Total proxy auth unknown types", func(s *client.Statistics) float64 { return float64(s.ProxyAuth.UnknownTypes) }),
+	counter(freeradius.TotalAccountingRequests, "freeradius_total_acct_requests", "Total acct requests", func(s *client.Statistics) float64 { return float64(s.Accounting.Requests) }),
+	counter(freeradius.TotalAccountingResponses, "freeradius_total_acct_responses", "Total acct responses", func(s *client.Statistics) float64 { return float64(s.Accounting.Responses) }),
+	counter(freeradius.TotalAcctDuplicateRequests, "freeradius_total_acct_duplicate_requests", "Total acct duplicate requests", func(s *client.Statistics) float64 { return float64(s.Accounting.DuplicateRequests) }),
+	counter(freeradius.TotalAcctMalformedRequests, "freeradius_total_acct_malformed_requests", "Total acct malformed requests", func(s *client.Statistics) float64 { return float64(s.Accounting.MalformedRequests) }),
+	counter(freeradius.TotalAcctInvalidRequests, "freeradius_total_acct_invalid_requests", "Total acct invalid requests", func(s *client.Statistics) float64 { return float64(s.Accounting.InvalidRequests) }),
+	counter(freeradius.TotalAcctDroppedRequests, "freeradius_total_acct_dropped_requests", "Total acct dropped requests", func(s *client.Statistics) float64 { return float64(s.Accounting.DroppedRequests) }),
+	counter(freeradius.TotalAcctUnknownTypes, "freeradius_total_acct_unknown_types", "Total acct unknown types", func(s *client.Statistics) float64 { return float64(s.Accounting.UnknownTypes) }),
+	counter(freeradius.TotalProxyAccountingRequests, "freeradius_total_proxy_acct_requests", "Total proxy acct requests", func(s *client.Statistics) float64 { return float64(s.ProxyAccounting.Requests) }),
+	counter(freeradius.TotalProxyAccountingResponses, "freeradius_total_proxy_acct_responses", "Total proxy acct responses", func(s *client.Statistics) float64 { return float64(s.ProxyAccounting.Responses) }),
+	counter(freeradius.TotalProxyAcctDuplicateRequests, "freeradius_total_proxy_acct_duplicate_requests", "Total proxy acct duplicate requests", func(s *client.Statistics) float64 { return float64(s.ProxyAccounting.DuplicateRequests) }),
+	counter(freeradius.TotalProxyAcctMalformedRequests, "freeradius_total_proxy_acct_malformed_requests", "Total proxy acct malformed requests", func(s *client.Statistics) float64 { return float64(s.ProxyAccounting.MalformedRequests) }),
+	counter(freeradius.TotalProxyAcctInvalidRequests, "freeradius_total_proxy_acct_invalid_requests", "Total proxy acct invalid requests", func(s *client.Statistics) float64 { return float64(s.ProxyAccounting.InvalidRequests) }),
+	counter(freeradius.TotalProxyAcctDroppedRequests, "freeradius_total_proxy_acct_dropped_requests", "Total proxy acct dropped requests", func(s *client.Statistics) float64 { return float64(s.ProxyAccounting.DroppedRequests) }),
+	counter(freeradius.TotalProxyAcctUnknownTypes, "freeradius_total_proxy_acct_unknown_types", "Total proxy acct unknown types", func(s *client.Statistics) float64 { return float64(s.ProxyAccounting.UnknownTypes) }),
+	gauge(freeradius.QueueLenInternal, "freeradius_queue_len_internal", "Internal queue length", func(s *client.Statistics) float64 { return float64(s.Internal.QueueLenInternal) }),
+	gauge(freeradius.QueueLenProxy, "freeradius_queue_len_proxy", "Proxy queue length", func(s *client.Statistics) float64 { return float64(s.Internal.QueueLenProxy) }),
+	gauge(freeradius.QueueLenAuth, "freeradius_queue_len_auth", "Auth queue length", func(s *client.Statistics) float64 { return float64(s.Internal.QueueLenAuth) }),
+	gauge(freeradius.QueueLenAcct, "freeradius_queue_len_acct", "Acct queue length", func(s *client.Statistics) float64 { return float64(s.Internal.QueueLenAcct) }),
+	gauge(freeradius.QueueLenDetail, "freeradius_queue_len_detail", "Detail queue length", func(s *client.Statistics) float64 { return float64(s.Internal.QueueLenDetail) }),
+}
+
+// statsMetrics returns the statistics of a target as metrics, skipping the attributes missing from its response.
+// Counters are carried over the wraps detected with the previous values remembered in state, which are
+// started over when the server restarted. The raw counter values are included when raw is set.
+func statsMetrics(t client.TargetStatistics, state *target, restarted, raw bool) []prometheus.Metric {
+	var metrics []prometheus.Metric
+	errorKind := client.StatsErrorKind(t.Statistics.Error)
+	for _, kind := range client.StatsErrorKinds {
+		metrics = append(metrics, prometheus.MustNewConstMetric(statsError, prometheus.GaugeValue, boolToFloat(kind == errorKind), t.Address, kind))
+	}
+	for _, m := range statMetrics {
+		if !t.Has(m.attr) {
+			continue
+		}
+		value := m.value(&t.Statistics)
+		if m.raw == nil {
+			metrics = append(metrics, prometheus.MustNewConstMetric(m.desc, m.valueType, value, t.Address))
+			continue
+		}
+
+		c := state.counter(m.attr, uint32(value), restarted)
+		metrics = append(metrics, prometheus.MustNewConstMetric(m.desc, m.valueType, float64(c.value()), t.Address))
+		if raw {
+			metrics = append(metrics, prometheus.MustNewConstMetric(m.raw, m.valueType, value, t.Address))
+		}
+	}
+	return metrics
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v3"
+
+	"github.com/bvantagelimited/freeradius_exporter/client"
+	"github.com/bvantagelimited/freeradius_exporter/modules"
+	"github.com/bvantagelimited/freeradius_exporter/secret"
+)
+
+// config holds the settings given as flags, environment variables or in the config file.
+type config struct {
+	help       bool
+	version    bool
+	configFile string
+
+	listenAddr          string
+	metricsPath         string
+	authToken           string
+	authTokenFile       string
+	allowedIPs          string
+	deniedIPs           string
+	trustedProxies      string
+	accessConfig        string
+	scrapeTimeoutOffset int
+	auditLogLimit       int
+
+	radiusTimeout         int
+	radiusAddr            string
+	radiusRetries         int
+	radiusRetryInterval   int
+	radiusRetryBackoff    float64
+	pollInterval          int
+	rawCounters           bool
+	homeServers           string
+	radiusSecret          string
+	radiusFallbackSecrets string
+	radiusSecretFile      string
+
+	webhookURLs    string
+	webhookSecret  string
+	webhookTimeout int
+	webhookRetries int
+
+	eventsSize int
+	eventsFile string
+
+	// flags the settings were parsed with
+	flags *flag.FlagSet
+}
+
+// secretFlags are the flags whose values are redacted from the settings.
+var secretFlags = []string{"radius.secret", "radius.fallback-secrets", "web.auth-token", "webhook.secret"}
+
+// homeServerSecret matches the secret options of the home servers.
+var homeServerSecret = regexp.MustCompile(`secret=[^&,]*`)
+
+// redactURL returns the scheme and host of the URL u only, as webhook URLs carry credentials in their
+// userinfo, path or query.
+func redactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" {
+		return "<redacted>"
+	}
+	redacted := parsed.Scheme + "://" + parsed.Host
+	if parsed.User != nil || (parsed.Path != "" && parsed.Path != "/") || parsed.RawQuery != "" {
+		redacted += "/<redacted>"
+	}
+	return redacted
+}
+
+// setting is the effective value of a flag.
+type setting struct {
+	Name  string
+	Value string
+}
+
+// settings returns the effective value of each flag, with the secrets redacted.
+func (c *config) settings() []setting {
+	var settings []setting
+	c.flags.VisitAll(func(f *flag.Flag) {
+		if f.Name == "help" || f.Name == "version" {
+			return
+		}
+		value := f.Value.String()
+		if value != "" && slices.Contains(secretFlags, f.Name) {
+			value = "<redacted>"
+		} else if f.Name == "radius.homeservers" {
+			value = homeServerSecret.ReplaceAllString(value, "secret=<redacted>")
+		} else if f.Name == "webhook.urls" && value != "" {
+			urls := strings.Split(value, ",")
+			for i, u := range urls {
+				urls[i] = redactURL(u)
+			}
+			value = strings.Join(urls, ",")
+		}
+		settings = append(settings, setting{Name: f.Name, Value: value})
+	})
+	return settings
+}
+
+// parseConfig parses the flags in args, falling back to the environment variables and the config file.
+func parseConfig(args []string) (*config, *flag.FlagSet, error) {
+	c := &config{}
+	fs := newFlagSet("freeradius_exporter", c)
+	err := parseFlags(fs, args)
+	return c, fs, err
+}
+
+// newFlagSet returns a flag set of the given name setting the fields of c, to which subcommands can add their own flags.
+func newFlagSet(name string, c *config) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	c.flags = fs
+	fs.BoolVar(&c.help, "help", false, "Display help")
+	fs.BoolVar(&c.version, "version", false, "Display version information")
+	fs.StringVar(&c.configFile, "config", "", "YAML or JSON config file (optional).")
+
+	fs.StringVar(&c.listenAddr, "web.listen-address", ":9812", "Address to listen on for web interface and telemetry.")
+	fs.StringVar(&c.metricsPath, "web.telemetry-path", "/metrics", "A path under which to expose metrics.")
+	fs.StringVar(&c.authToken, "web.auth-token", "", "Auth token required in X-Auth-Token header to access /metrics (optional).")
+	fs.StringVar(&c.authTokenFile, "web.auth-token-file", "", "File containing the auth token, re-read on change, defaults to the 'web-auth-token' systemd credential (optional).")
+	fs.StringVar(&c.allowedIPs, "web.allowed-ips", "", "Comma-separated list of IPs or CIDR ranges allowed to access /metrics (optional).")
+	fs.StringVar(&c.deniedIPs, "web.denied-ips", "", "Comma-separated list of IPs or CIDR ranges denied access to /metrics (optional).")
+	fs.StringVar(&c.trustedProxies, "web.trusted-proxies", "", "Comma-separated list of proxy IPs or CIDR ranges whose X-Forwarded-For header is trusted (optional).")
+	fs.StringVar(&c.accessConfig, "web.access-config", "", "JSON file with named tokens and per-path access rules (optional).")
+	fs.IntVar(&c.scrapeTimeoutOffset, "web.scrape-timeout-offset", 500, "Time, in milliseconds, subtracted from the Prometheus scrape timeout to send the metrics fetched so far.")
+	fs.IntVar(&c.auditLogLimit, "web.audit-log-limit", 10, "Maximum number of denied requests logged per minute, 0 disables the audit log.")
+	fs.IntVar(&c.radiusTimeout, "radius.timeout", 5000, "Timeout, in milliseconds [RADIUS_TIMEOUT].")
+	fs.StringVar(&c.radiusAddr, "radius.address", "127.0.0.1:18121", "Address of FreeRADIUS status server [RADIUS_ADDRESS].")
+	fs.IntVar(&c.radiusRetries, "radius.retries", 0, "Number of times a status request is sent again after a timeout [RADIUS_RETRIES].")
+	fs.IntVar(&c.radiusRetryInterval, "radius.retry-interval", 0, "Time to wait before the first retry, in milliseconds [RADIUS_RETRY_INTERVAL].")
+	fs.Float64Var(&c.radiusRetryBackoff, "radius.retry-backoff", 2, "Factor the retry interval is multiplied with after each retry [RADIUS_RETRY_BACKOFF].")
+	fs.IntVar(&c.pollInterval, "radius.poll-interval", 0, "Interval, in milliseconds, to fetch statistics in the background and serve scrapes from the last result, 0 fetches on every scrape [RADIUS_POLL_INTERVAL].")
+	fs.BoolVar(&c.rawCounters, "radius.raw-counters", false, "Also export the counters as the 32-bit values reported by FreeRADIUS, suffixed with _raw [RADIUS_RAW_COUNTERS].")
+	fs.StringVar(&c.homeServers, "radius.homeservers", "", "List of FreeRADIUS home servers to check, e.g. '172.28.1.2:1812:auth,172.28.1.3:1813:acct?timeout=2000&retries=1' [RADIUS_HOMESERVERS].")
+	fs.StringVar(&c.radiusSecret, "radius.secret", "adminsecret", "FreeRADIUS client secret [RADIUS_SECRET].")
+	fs.StringVar(&c.radiusFallbackSecrets, "radius.fallback-secrets", "", "Comma-separated list of secrets tried in order when the status server does not answer to radius.secret [RADIUS_FALLBACK_SECRETS].")
+	fs.StringVar(&c.radiusSecretFile, "radius.secret-file", "", "File containing the FreeRADIUS client secrets, one per line in the order they are tried, re-read on change, defaults to the 'radius-secret' systemd credential [RADIUS_SECRET_FILE].")
+	fs.StringVar(&c.webhookURLs, "webhook.urls", "", "Comma-separated list of URLs to POST home server state changes to, requires radius.poll-interval [WEBHOOK_URLS].")
+	fs.StringVar(&c.webhookSecret, "webhook.secret", "", "Secret to sign the webhook events with HMAC-SHA256 (optional) [WEBHOOK_SECRET].")
+	fs.IntVar(&c.webhookTimeout, "webhook.timeout", 5000, "Timeout of a webhook request, in milliseconds [WEBHOOK_TIMEOUT].")
+	fs.IntVar(&c.webhookRetries, "webhook.retries", 3, "Number of times a webhook event is sent again after a failure [WEBHOOK_RETRIES].")
+	fs.IntVar(&c.eventsSize, "events.size", 100, "Number of events kept in memory [EVENTS_SIZE].")
+	fs.StringVar(&c.eventsFile, "events.file", "", "File the events are saved to and loaded from on start (optional) [EVENTS_FILE].")
+	return fs
+}
+
+// parseFlags parses the flags of fs in args, falling back to the environment variables and the config file.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	return ff.Parse(fs, args, ff.WithEnvVarNoPrefix(), ff.WithConfigFileFlag("config"), ff.WithConfigFileParser(modules.FlagParser))
+}
+
+// radiusProblems returns the radius flags out of range, with the same ranges as the options of the home servers.
+// The exporter, the reloads and check-config all reject them.
+func (c *config) radiusProblems() []error {
+	var problems []error
+	if c.radiusTimeout <= 0 {
+		problems = append(problems, fmt.Errorf("radius.timeout: must be positive, got %v", c.radiusTimeout))
+	}
+	if c.radiusRetries < 0 {
+		problems = append(problems, fmt.Errorf("radius.retries: must not be negative, got %v", c.radiusRetries))
+	}
+	if c.radiusRetryInterval < 0 {
+		problems = append(problems, fmt.Errorf("radius.retry-interval: must not be negative, got %v", c.radiusRetryInterval))
+	}
+	if c.radiusRetryBackoff < 1 {
+		problems = append(problems, fmt.Errorf("radius.retry-backoff: must be at least 1, got %v", c.radiusRetryBackoff))
+	}
+	if c.pollInterval < 0 {
+		problems = append(problems, fmt.Errorf("radius.poll-interval: must not be negative, got %v", c.pollInterval))
+	}
+	return problems
+}
+
+// newRadiusClient creates the FreeRADIUS client of the config, along with the secret file it was
+// created with, if any.
+func newRadiusClient(c *config) (*client.FreeRADIUSClient, *secret.File, error) {
+	if problems := c.radiusProblems(); len(problems) > 0 {
+		return nil, nil, errors.Join(problems...)
+	}
+
+	secrets := []string{c.radiusSecret}
+	if c.radiusFallbackSecrets != "" {
+		secrets = append(secrets, strings.Split(c.radiusFallbackSecrets, ",")...)
+	}
+
+	var secretFile *secret.File
+	if path := secret.Lookup(c.radiusSecretFile, "radius-secret"); path != "" {
+		var err error
+		if secretFile, err = secret.NewFile(path); err != nil {
+			return nil, nil, err
+		}
+		secrets = secret.Lines(secretFile.Value())
+	}
+
+	opts := client.Options{
+		Secrets: secrets,
+		Timeout: time.Duration(c.radiusTimeout) * time.Millisecond,
+		Retry: client.RetryPolicy{
+			Count:    c.radiusRetries,
+			Interval: time.Duration(c.radiusRetryInterval) * time.Millisecond,
+			Backoff:  c.radiusRetryBackoff,
+		},
+	}
+
+	var fileConfig *modules.Config
+	if c.configFile != "" {
+		var err error
+		if fileConfig, err = modules.Load(c.configFile); err != nil {
+			return nil, nil, err
+		}
+		opts = fileConfig.DefaultOptions(opts)
+	}
+
+	hs, err := client.ParseHomeServers(c.homeServers, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	addr, statusOpts := c.radiusAddr, opts
+	if fileConfig != nil {
+		var targets []client.HomeServer
+		addr, statusOpts, targets = fileConfig.Apply(addr, opts)
+		hs = append(hs, targets...)
+	}
+
+	radiusClient, err := client.NewFreeRADIUSClient(addr, hs, statusOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return radiusClient, secretFile, nil
+}
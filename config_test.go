@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewRadiusClientRetryFlags(t *testing.T) {
+	for _, tt := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-radius.timeout", "0"}, "radius.timeout: must be positive, got 0"},
+		{[]string{"-radius.retries", "-1"}, "radius.retries: must not be negative, got -1"},
+		{[]string{"-radius.retry-interval", "-5"}, "radius.retry-interval: must not be negative, got -5"},
+		{[]string{"-radius.retry-backoff", "0.5"}, "radius.retry-backoff: must be at least 1, got 0.5"},
+		{[]string{"-radius.poll-interval", "-1"}, "radius.poll-interval: must not be negative, got -1"},
+		{[]string{"-radius.retries", "2", "-radius.retry-interval", "100", "-radius.retry-backoff", "1"}, ""},
+	} {
+		cfg, _, err := parseConfig(tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = newRadiusClient(cfg)
+		if tt.expected == "" && err != nil {
+			t.Errorf("unexpected error for %v: %v", tt.args, err)
+		} else if tt.expected != "" && (err == nil || !strings.Contains(err.Error(), tt.expected)) {
+			t.Errorf("expected error %q for %v, got %v", tt.expected, tt.args, err)
+		}
+	}
+}
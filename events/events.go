@@ -0,0 +1,137 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Kinds of events.
+const (
+	KindStateChange       = "state_change"
+	KindRestart           = "restart"
+	KindHUP               = "hup"
+	KindStatsError        = "stats_error"
+	KindExchangeFailure   = "exchange_failure"
+	KindExchangeRecovered = "exchange_recovered"
+)
+
+// Event is a notable change seen by the collector.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Address string    `json:"address"`
+	Message string    `json:"message"`
+}
+
+// Log keeps the last events in a ring buffer, optionally persisted to a file.
+type Log struct {
+	mutex  sync.RWMutex
+	events []Event
+	next   int
+	full   bool
+	path   string
+}
+
+// NewLog creates a Log of size events. When path is set, the events are saved to it on every change,
+// and the events saved by a previous run are loaded from it.
+func NewLog(size int, path string) (*Log, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid event log size %v", size)
+	}
+	l := &Log{events: make([]Event, size), path: path}
+	if path == "" {
+		return l, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed reading event log '%v': %w", path, err)
+	}
+	var saved []Event
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed parsing event log '%v': %w", path, err)
+	}
+	for _, e := range saved {
+		l.add(e)
+	}
+	return l, nil
+}
+
+// Add adds an event, replacing the oldest one when the log is full.
+func (l *Log) Add(e Event) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.add(e)
+	if l.path != "" {
+		if err := l.save(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (l *Log) add(e Event) {
+	l.events[l.next] = e
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Events returns the events, oldest first.
+func (l *Log) Events() []Event {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.ordered()
+}
+
+func (l *Log) ordered() []Event {
+	if !l.full {
+		return append([]Event{}, l.events[:l.next]...)
+	}
+	return append(append([]Event{}, l.events[l.next:]...), l.events[:l.next]...)
+}
+
+// save writes the events to a temporary file renamed over the log file, so that it is never left half written.
+func (l *Log) save() error {
+	data, err := json.Marshal(l.ordered())
+	if err != nil {
+		return fmt.Errorf("failed encoding event log: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed saving event log '%v': %w", l.path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed saving event log '%v': %w", l.path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed saving event log '%v': %w", l.path, err)
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return fmt.Errorf("failed saving event log '%v': %w", l.path, err)
+	}
+	return nil
+}
+
+// ServeHTTP serves the events as JSON, newest first.
+func (l *Log) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	events := l.Events()
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
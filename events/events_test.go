@@ -0,0 +1,34 @@
+package events
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.json")
+	l, err := NewLog(3, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var added []Event
+	for i := 0; i < 5; i++ {
+		e := Event{Time: time.Unix(int64(i), 0).UTC(), Kind: KindRestart, Address: "127.0.0.1:18121"}
+		added = append(added, e)
+		l.Add(e)
+	}
+	if got := l.Events(); !reflect.DeepEqual(got, added[2:]) {
+		t.Errorf("expected %+v, got %+v", added[2:], got)
+	}
+
+	loaded, err := NewLog(3, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Events(); !reflect.DeepEqual(got, added[2:]) {
+		t.Errorf("expected %+v after loading, got %+v", added[2:], got)
+	}
+}
@@ -222,3 +222,99 @@ func lookupVendor(p *radius.Packet, typ byte) (attr radius.Attribute, ok bool) {
 	}
 	return
 }
+
+// Names of the statistics attributes in the FreeRADIUS dictionary.
+var Names = map[byte]string{
+	StatisticsType: "FreeRADIUS-Statistics-Type",
+
+	TotalAccessRequests:        "FreeRADIUS-Total-Access-Requests",
+	TotalAccessAccepts:         "FreeRADIUS-Total-Access-Accepts",
+	TotalAccessRejects:         "FreeRADIUS-Total-Access-Rejects",
+	TotalAccessChallenges:      "FreeRADIUS-Total-Access-Challenges",
+	TotalAuthResponses:         "FreeRADIUS-Total-Auth-Responses",
+	TotalAuthDuplicateRequests: "FreeRADIUS-Total-Auth-Duplicate-Requests",
+	TotalAuthMalformedRequests: "FreeRADIUS-Total-Auth-Malformed-Requests",
+	TotalAuthInvalidRequests:   "FreeRADIUS-Total-Auth-Invalid-Requests",
+	TotalAuthDroppedRequests:   "FreeRADIUS-Total-Auth-Dropped-Requests",
+	TotalAuthUnknownTypes:      "FreeRADIUS-Total-Auth-Unknown-Types",
+
+	TotalProxyAccessRequests:        "FreeRADIUS-Total-Proxy-Access-Requests",
+	TotalProxyAccessAccepts:         "FreeRADIUS-Total-Proxy-Access-Accepts",
+	TotalProxyAccessRejects:         "FreeRADIUS-Total-Proxy-Access-Rejects",
+	TotalProxyAccessChallenges:      "FreeRADIUS-Total-Proxy-Access-Challenges",
+	TotalProxyAuthResponses:         "FreeRADIUS-Total-Proxy-Auth-Responses",
+	TotalProxyAuthDuplicateRequests: "FreeRADIUS-Total-Proxy-Auth-Duplicate-Requests",
+	TotalProxyAuthMalformedRequests: "FreeRADIUS-Total-Proxy-Auth-Malformed-Requests",
+	TotalProxyAuthInvalidRequests:   "FreeRADIUS-Total-Proxy-Auth-Invalid-Requests",
+	TotalProxyAuthDroppedRequests:   "FreeRADIUS-Total-Proxy-Auth-Dropped-Requests",
+	TotalProxyAuthUnknownTypes:      "FreeRADIUS-Total-Proxy-Auth-Unknown-Types",
+
+	TotalAccountingRequests:    "FreeRADIUS-Total-Accounting-Requests",
+	TotalAccountingResponses:   "FreeRADIUS-Total-Accounting-Responses",
+	TotalAcctDuplicateRequests: "FreeRADIUS-Total-Acct-Duplicate-Requests",
+	TotalAcctMalformedRequests: "FreeRADIUS-Total-Acct-Malformed-Requests",
+	TotalAcctInvalidRequests:   "FreeRADIUS-Total-Acct-Invalid-Requests",
+	TotalAcctDroppedRequests:   "FreeRADIUS-Total-Acct-Dropped-Requests",
+	TotalAcctUnknownTypes:      "FreeRADIUS-Total-Acct-Unknown-Types",
+
+	TotalProxyAccountingRequests:    "FreeRADIUS-Total-Proxy-Accounting-Requests",
+	TotalProxyAccountingResponses:   "FreeRADIUS-Total-Proxy-Accounting-Responses",
+	TotalProxyAcctDuplicateRequests: "FreeRADIUS-Total-Proxy-Acct-Duplicate-Requests",
+	TotalProxyAcctMalformedRequests: "FreeRADIUS-Total-Proxy-Acct-Malformed-Requests",
+	TotalProxyAcctInvalidRequests:   "FreeRADIUS-Total-Proxy-Acct-Invalid-Requests",
+	TotalProxyAcctDroppedRequests:   "FreeRADIUS-Total-Proxy-Acct-Dropped-Requests",
+	TotalProxyAcctUnknownTypes:      "FreeRADIUS-Total-Proxy-Acct-Unknown-Types",
+
+	QueueLenInternal: "FreeRADIUS-Queue-Len-Internal",
+	QueueLenProxy:    "FreeRADIUS-Queue-Len-Proxy",
+	QueueLenAuth:     "FreeRADIUS-Queue-Len-Auth",
+	QueueLenAcct:     "FreeRADIUS-Queue-Len-Acct",
+	QueueLenDetail:   "FreeRADIUS-Queue-Len-Detail",
+
+	ServerIPAddress:           "FreeRADIUS-Stats-Server-IP-Address",
+	ServerPort:                "FreeRADIUS-Stats-Server-Port",
+	ServerOutstandingRequests: "FreeRADIUS-Stats-Server-Outstanding-Requests",
+	ServerState:               "FreeRADIUS-Stats-Server-State",
+	ServerTimeOfDeath:         "FreeRADIUS-Stats-Server-Time-Of-Death",
+	ServerTimeOfLife:          "FreeRADIUS-Stats-Server-Time-Of-Life",
+	StartTime:                 "FreeRADIUS-Stats-Start-Time",
+	HUPTime:                   "FreeRADIUS-Stats-HUP-Time",
+	EmaWindow:                 "FreeRADIUS-Server-EMA-Window",
+	EmaUsecWindow1:            "FreeRADIUS-Server-EMA-USEC-Window-1",
+	EmaUsecWindow10:           "FreeRADIUS-Server-EMA-USEC-Window-10",
+	QueuePPSIn:                "FreeRADIUS-Queue-PPS-In",
+	QueuePPSOut:               "FreeRADIUS-Queue-PPS-Out",
+	QueueUsePercentage:        "FreeRADIUS-Queue-Use-Percentage",
+	LastPacketRecv:            "FreeRADIUS-Stats-Last-Packet-Recv",
+	LastPacketSent:            "FreeRADIUS-Stats-Last-Packet-Sent",
+	StatsError:                "FreeRADIUS-Stats-Error",
+}
+
+// VendorAttribute is a FreeRADIUS attribute of a packet.
+type VendorAttribute struct {
+	Type  byte
+	Value radius.Attribute
+}
+
+// VendorAttributes returns the FreeRADIUS attributes of the packet, in order.
+func VendorAttributes(p *radius.Packet) []VendorAttribute {
+	var attrs []VendorAttribute
+	for _, avp := range p.Attributes {
+		if avp.Type != rfc2865.VendorSpecific_Type {
+			continue
+		}
+		vendorID, vsa, err := radius.VendorSpecific(avp.Attribute)
+		if err != nil || vendorID != VendorID {
+			continue
+		}
+		for len(vsa) >= 3 {
+			vsaTyp, vsaLen := vsa[0], vsa[1]
+			if int(vsaLen) > len(vsa) || vsaLen < 3 {
+				break
+			}
+			attrs = append(attrs, VendorAttribute{Type: vsaTyp, Value: vsa[2:int(vsaLen)]})
+			vsa = vsa[int(vsaLen):]
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,40 @@
+// Package radiustest provides a status server answering over UDP for the tests.
+package radiustest
+
+import (
+	"net"
+	"testing"
+
+	"layeh.com/radius"
+
+	"github.com/bvantagelimited/freeradius_exporter/freeradius"
+)
+
+// NewServer starts a server answering with handler to the requests sent with secret, closed at the end of the
+// test. It returns its address.
+func NewServer(t testing.TB, secret string, handler radius.HandlerFunc) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &radius.PacketServer{
+		Handler:      handler,
+		SecretSource: radius.StaticSecretSource([]byte(secret)),
+	}
+	go server.Serve(conn)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn.LocalAddr().String()
+}
+
+// Accept answers with 42 total access requests.
+func Accept(w radius.ResponseWriter, r *radius.Request) {
+	response := r.Response(radius.CodeAccessAccept)
+	freeradius.SetValue(response, freeradius.TotalAccessRequests, radius.NewInteger(42))
+	w.Write(response)
+}
+
+// Silent never answers.
+func Silent(w radius.ResponseWriter, r *radius.Request) {}
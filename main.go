@@ -1,149 +1,179 @@
 package main
 
 import (
-	"flag"
+	"context"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/peterbourgon/ff/v3"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 
-	"github.com/bvantagelimited/freeradius_exporter/client"
+	"github.com/bvantagelimited/freeradius_exporter/access"
 	"github.com/bvantagelimited/freeradius_exporter/collector"
+	"github.com/bvantagelimited/freeradius_exporter/events"
+	"github.com/bvantagelimited/freeradius_exporter/secret"
+	"github.com/bvantagelimited/freeradius_exporter/webhook"
 )
 
 var version, commit, date string
 
+// secretReloadInterval is how often secret files are checked for changes.
+const secretReloadInterval = 10 * time.Second
+
+// flagTokenName is the name of the token given with web.auth-token or web.auth-token-file, reserved in the access config.
+const flagTokenName = "web.auth-token"
+
+// shutdownTimeout is how long in-flight requests are waited for on shutdown.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
-	fs := flag.NewFlagSet("freeradius_exporter", flag.ContinueOnError)
-	appHelp := fs.Bool("help", false, "Display help")
-	appVersion := fs.Bool("version", false, "Display version information")
-	_ = fs.String("config", "", "Config file (optional)")
-
-	listenAddr := fs.String("web.listen-address", ":9812", "Address to listen on for web interface and telemetry.")
-	metricsPath := fs.String("web.telemetry-path", "/metrics", "A path under which to expose metrics.")
-	metricsAuthToken := fs.String("web.auth-token", "", "Auth token required in X-Auth-Token header to access /metrics (optional).")
-	metricsAllowedIPs := fs.String("web.allowed-ips", "", "Comma-separated list of IPs or CIDR ranges allowed to access /metrics (optional).")
-	radiusTimeout := fs.Int("radius.timeout", 5000, "Timeout, in milliseconds [RADIUS_TIMEOUT].")
-	radiusAddr := fs.String("radius.address", "127.0.0.1:18121", "Address of FreeRADIUS status server [RADIUS_ADDRESS].")
-	homeServers := fs.String("radius.homeservers", "", "List of FreeRADIUS home servers to check, e.g. '172.28.1.2:1812:auth,172.28.1.3:1813:acct' [RADIUS_HOMESERVERS].")
-	radiusSecret := fs.String("radius.secret", "adminsecret", "FreeRADIUS client secret [RADIUS_SECRET].")
-
-	err := ff.Parse(fs, os.Args[1:], ff.WithEnvVarNoPrefix(), ff.WithConfigFileFlag("config"), ff.WithConfigFileParser(ff.JSONParser))
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check-config":
+			os.Exit(checkConfig(os.Args[2:], os.Stdout))
+		case "query":
+			os.Exit(query(os.Args[2:], os.Stdout))
+		case "top":
+			os.Exit(top(os.Args[2:], os.Stdout))
+		}
+	}
+
+	cfg, fs, err := parseConfig(os.Args[1:])
 	if err != nil {
 		println(err.Error())
 		os.Exit(1)
 	}
 
-	if *appHelp {
+	if cfg.help {
 		fs.PrintDefaults()
 		os.Exit(0)
 	}
 
-	if *appVersion {
+	if cfg.version {
 		println(filepath.Base(os.Args[0]), version, commit, date)
 		os.Exit(0)
 	}
 
-	allowedCIDRs, err := parseAllowedIPs(*metricsAllowedIPs)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	policy, err := newAccessPolicy(cfg.accessConfig, cfg.authToken, secret.Lookup(cfg.authTokenFile, "web-auth-token"), cfg.allowedIPs, cfg.deniedIPs, cfg.trustedProxies)
 	if err != nil {
-		println(err)
+		println(err.Error())
 		os.Exit(1)
 	}
 
+	policy.Watch(ctx, secretReloadInterval)
+
 	registry := prometheus.NewRegistry()
+	policy.SetAuditor(access.NewAuditor(registry, cfg.auditLogLimit, time.Minute))
+	httpMetrics := newHTTPMetrics(registry)
 
-	hs := strings.Split(*homeServers, ",")
+	radiusClient, secretFile, err := newRadiusClient(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	radiusCollector := collector.NewFreeRADIUSCollector(radiusClient)
+	configReloader := newReloader(ctx, registry, os.Args[1:], radiusCollector)
+	configReloader.apply(cfg, radiusClient, secretFile)
+	go configReloader.watchSignals()
 
-	radiusClient, err := client.NewFreeRADIUSClient(*radiusAddr, hs, *radiusSecret, *radiusTimeout)
+	eventLog, err := events.NewLog(cfg.eventsSize, cfg.eventsFile)
 	if err != nil {
 		log.Fatal(err)
 	}
+	radiusCollector.OnEvent(eventLog.Add)
 
-	registry.MustRegister(collector.NewFreeRADIUSCollector(radiusClient))
+	if cfg.webhookURLs != "" {
+		if cfg.pollInterval <= 0 {
+			log.Fatal("webhook.urls requires radius.poll-interval")
+		}
+		notifier := webhook.NewNotifier(strings.Split(cfg.webhookURLs, ","), webhook.Options{
+			Secret:        cfg.webhookSecret,
+			Timeout:       time.Duration(cfg.webhookTimeout) * time.Millisecond,
+			Retries:       cfg.webhookRetries,
+			RetryInterval: time.Second,
+		})
+		go notifier.Run(ctx)
+		radiusCollector.OnStateChange(func(change collector.StateChange) {
+			notifier.Notify(change)
+		})
+	}
+	if cfg.pollInterval > 0 {
+		go radiusCollector.Poll(ctx, time.Duration(cfg.pollInterval)*time.Millisecond)
+	}
 
-	metricsHandler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
-	http.Handle(*metricsPath, withTokenOrIP(*metricsAuthToken, allowedCIDRs, metricsHandler))
+	metricsHandler := scrapeHandler(registry, radiusCollector, time.Duration(cfg.scrapeTimeoutOffset)*time.Millisecond)
+	http.Handle(cfg.metricsPath, httpMetrics.instrument(cfg.metricsPath, policy.Protect(metricsHandler)))
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`<html>
-			<head><title>FreeRADIUS Exporter</title></head>
-			<body>
-			<h1>FreeRADIUS Exporter</h1>
-			<p><a href='` + *metricsPath + `'>Metrics</a></p>
-			</body>
-			</html>`))
-	})
+	http.Handle("/api/v1/stats", httpMetrics.instrument("/api/v1/stats", policy.Protect(statsHandler(radiusCollector))))
+	http.Handle("/api/v1/events", httpMetrics.instrument("/api/v1/events", policy.Protect(eventLog)))
+	http.Handle("/-/healthy", httpMetrics.instrument("/-/healthy", http.HandlerFunc(healthy)))
+	http.Handle("/-/ready", httpMetrics.instrument("/-/ready", readyHandler(radiusCollector)))
+	http.Handle("/-/reload", httpMetrics.instrument("/-/reload", policy.Protect(configReloader)))
+	http.Handle("/", httpMetrics.instrument("/", landingPage(cfg.metricsPath, policy, radiusCollector, eventLog, configReloader.settings)))
 
 	srv := &http.Server{}
-	listener, err := net.Listen("tcp4", *listenAddr)
+	listener, err := net.Listen("tcp4", cfg.listenAddr)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	log.Printf("Providing metrics at %s%s", *listenAddr, *metricsPath)
-	log.Fatal(srv.Serve(listener))
-}
-
-
-func parseAllowedIPs(input string) ([]*net.IPNet, error) {
-	if input == "" {
-		return nil, nil
-	}
-	entries := strings.Split(input, ",")
-	var result []*net.IPNet
-	for _, entry := range entries {
-		entry = strings.TrimSpace(entry)
-		if ip := net.ParseIP(entry); ip != nil {
-			result = append(result, &net.IPNet{
-				IP:   ip,
-				Mask: net.CIDRMask(32, 32),
-			})
-			continue
-		}
-		if _, cidr, err := net.ParseCIDR(entry); err == nil {
-			result = append(result, cidr)
-			continue
+	log.Printf("Providing metrics at %s%s", cfg.listenAddr, cfg.metricsPath)
+	go func() {
+		if err := srv.Serve(listener); err != http.ErrServerClosed {
+			log.Fatal(err)
 		}
-		return nil, fmt.Errorf("Invalid IP or CIDR : %s", entry)
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down, waiting for in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println(err)
 	}
-	return result, nil
 }
 
-func withTokenOrIP(token string, allowed []*net.IPNet, next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// no filters defined → PASS
-		if token == "" && len(allowed) == 0 {
-			next.ServeHTTP(w, r)
-			return
-		}
-
-		// token valid → PASS
-		if token != "" && r.Header.Get("X-Auth-Token") == token {
-			next.ServeHTTP(w, r)
-			return
+// newAccessPolicy merges the access config file with the web.* flags, which apply to paths without a rule of their own.
+func newAccessPolicy(file, token, tokenFile, allowedIPs, deniedIPs, trustedProxies string) (*access.Policy, error) {
+	var cfg access.Config
+	if file != "" {
+		var err error
+		if cfg, err = access.LoadConfig(file); err != nil {
+			return nil, err
 		}
+	}
 
-		// valid CIDR or IP → PASS
-		if len(allowed) > 0 {
-			ipStr, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err == nil {
-				ip := net.ParseIP(ipStr)
-				for _, ipNet := range allowed {
-					if ipNet.Contains(ip) {
-						next.ServeHTTP(w, r)
-						return
-					}
-				}
+	if tokenFile != "" || token != "" {
+		for _, t := range cfg.Tokens {
+			if t.Name == flagTokenName {
+				return nil, fmt.Errorf("token name '%v' is reserved for the token given with the web.auth-token flags", flagTokenName)
 			}
 		}
+	}
+	if tokenFile != "" {
+		cfg.Tokens = append(cfg.Tokens, access.Token{Name: flagTokenName, File: tokenFile})
+	} else if token != "" {
+		cfg.Tokens = append(cfg.Tokens, access.Token{Name: flagTokenName, Value: token})
+	}
+	if allowedIPs != "" {
+		cfg.Default.Allow = append(cfg.Default.Allow, strings.Split(allowedIPs, ",")...)
+	}
+	if deniedIPs != "" {
+		cfg.Default.Deny = append(cfg.Default.Deny, strings.Split(deniedIPs, ",")...)
+	}
+	if trustedProxies != "" {
+		cfg.TrustedProxies = append(cfg.TrustedProxies, strings.Split(trustedProxies, ",")...)
+	}
 
-		http.Error(w, "Forbidden", http.StatusForbidden)
-	})
+	return access.NewPolicy(cfg)
 }
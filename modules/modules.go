@@ -0,0 +1,351 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/bvantagelimited/freeradius_exporter/client"
+)
+
+// Types of modules.
+const (
+	// TypeStatus queries the status server itself, there is at most one target of this type.
+	TypeStatus = "status"
+	// TypeHomeServer queries the statistics of a home server, detecting its type.
+	TypeHomeServer = "home_server"
+	// TypeAuth queries the statistics of an authentication home server.
+	TypeAuth = "auth"
+	// TypeAcct queries the statistics of an accounting home server.
+	TypeAcct = "acct"
+)
+
+// Sections of the config file, the other top-level keys are flags.
+var sections = []string{"defaults", "modules", "targets"}
+
+// builtin are the modules available without being declared.
+var builtin = map[string]Module{
+	TypeStatus:     {Type: TypeStatus},
+	TypeHomeServer: {Type: TypeHomeServer},
+	TypeAuth:       {Type: TypeAuth},
+	TypeAcct:       {Type: TypeAcct},
+}
+
+// Settings of the status requests. Settings not given are inherited, from the module of a target,
+// then from the defaults, then from the flags.
+type Settings struct {
+	Timeout       *time.Duration `yaml:"timeout"`
+	Retries       *int           `yaml:"retries"`
+	RetryInterval *time.Duration `yaml:"retry_interval"`
+	RetryBackoff  *float64       `yaml:"retry_backoff"`
+	Secrets       []string       `yaml:"secrets"`
+}
+
+var settingsKeys = []string{"timeout", "retries", "retry_interval", "retry_backoff", "secrets"}
+
+// Module is a named way of querying a target.
+type Module struct {
+	Type string `yaml:"type"`
+	// Direct sends the status request to a home server itself instead of the status server.
+	Direct   bool `yaml:"direct"`
+	Settings `yaml:",inline"`
+}
+
+// Target is a server queried with a module.
+type Target struct {
+	Address  string `yaml:"address"`
+	Module   string `yaml:"module"`
+	Settings `yaml:",inline"`
+}
+
+// Config holds the modules and targets of a YAML config file.
+type Config struct {
+	Defaults Settings          `yaml:"defaults"`
+	Modules  map[string]Module `yaml:"modules"`
+	Targets  []Target          `yaml:"targets"`
+}
+
+// Load reads and validates the config file at path.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading config file: %w", err)
+	}
+	cfg, errs := Parse(data)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config file '%v': %w", path, errors.Join(errs...))
+	}
+	return cfg, nil
+}
+
+// Parse parses and validates a config file, returning all the problems found, each with its line number.
+func Parse(data []byte) (*Config, []error) {
+	cfg := &Config{}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, []error{err}
+	}
+	if len(doc.Content) == 0 {
+		return cfg, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, []error{lineError(root, "expected a mapping")}
+	}
+
+	var errs []error
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "defaults":
+			errs = append(errs, checkKeys(value, settingsKeys...)...)
+			if err := value.Decode(&cfg.Defaults); err != nil {
+				errs = appendDecodeError(errs, err)
+			} else {
+				errs = append(errs, checkSettings(value, cfg.Defaults, "defaults")...)
+			}
+		case "modules":
+			errs = append(errs, parseModules(value, cfg)...)
+		case "targets":
+			errs = append(errs, parseTargets(value, cfg)...)
+		}
+	}
+	return cfg, errs
+}
+
+func parseModules(node *yaml.Node, cfg *Config) []error {
+	if node.Kind != yaml.MappingNode {
+		return []error{lineError(node, "modules: expected a mapping of names to modules")}
+	}
+	var errs []error
+	cfg.Modules = make(map[string]Module)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, value := node.Content[i].Value, node.Content[i+1]
+		errs = append(errs, checkKeys(value, append([]string{"type", "direct"}, settingsKeys...)...)...)
+
+		var m Module
+		if err := value.Decode(&m); err != nil {
+			errs = appendDecodeError(errs, err)
+			continue
+		}
+		switch m.Type {
+		case TypeStatus, TypeHomeServer, TypeAuth, TypeAcct:
+		case "":
+			errs = append(errs, lineError(value, "module '%v' has no type", name))
+		default:
+			errs = append(errs, lineError(value, "module '%v' has unknown type '%v', expected status, home_server, auth or acct", name, m.Type))
+		}
+		errs = append(errs, checkSettings(value, m.Settings, fmt.Sprintf("module '%v'", name))...)
+		if m.Direct && m.Type == TypeStatus {
+			errs = append(errs, lineError(value, "module '%v' of type status cannot be direct", name))
+		}
+		if len(m.Secrets) > 0 && m.Type != TypeStatus && !m.Direct {
+			errs = append(errs, lineError(value, "module '%v' is queried through the status server, its secrets need direct", name))
+		}
+		cfg.Modules[name] = m
+	}
+	return errs
+}
+
+func parseTargets(node *yaml.Node, cfg *Config) []error {
+	if node.Kind != yaml.SequenceNode {
+		return []error{lineError(node, "targets: expected a list of targets")}
+	}
+	var errs []error
+	var status *yaml.Node
+	for _, value := range node.Content {
+		errs = append(errs, checkKeys(value, append([]string{"address", "module"}, settingsKeys...)...)...)
+
+		var t Target
+		if err := value.Decode(&t); err != nil {
+			errs = appendDecodeError(errs, err)
+			continue
+		}
+		if _, _, err := net.SplitHostPort(t.Address); err != nil {
+			errs = append(errs, lineError(value, "invalid address of target '%v': %v", t.Address, err))
+		}
+		errs = append(errs, checkSettings(value, t.Settings, fmt.Sprintf("target '%v'", t.Address))...)
+		m, ok := cfg.module(t.Module)
+		if !ok {
+			errs = append(errs, lineError(value, "unknown module '%v' of target '%v'", t.Module, t.Address))
+		} else if len(t.Secrets) > 0 && m.Type != TypeStatus && !m.Direct {
+			errs = append(errs, lineError(value, "target '%v' is queried through the status server, its secrets need a direct module", t.Address))
+		} else if m.Type == TypeStatus {
+			if status != nil {
+				errs = append(errs, lineError(value, "target '%v' is a second status server, the first one is on line %d", t.Address, status.Line))
+			}
+			status = value
+		}
+		cfg.Targets = append(cfg.Targets, t)
+	}
+	return errs
+}
+
+// module returns the module of the given name, declared or builtin. Targets without a module are home servers.
+func (c *Config) module(name string) (Module, bool) {
+	if name == "" {
+		name = TypeHomeServer
+	}
+	if m, ok := c.Modules[name]; ok {
+		return m, true
+	}
+	m, ok := builtin[name]
+	return m, ok
+}
+
+// DefaultOptions returns the options given as flags overridden with the defaults of the config.
+func (c *Config) DefaultOptions(flags client.Options) client.Options {
+	return c.Defaults.apply(flags)
+}
+
+// Apply returns the address and options of the status server and the home servers of the targets, with the
+// settings they do not give taken from defaults. The status server given as flag is kept when there is no
+// status target.
+func (c *Config) Apply(statusAddr string, defaults client.Options) (string, client.Options, []client.HomeServer) {
+	statusOpts := defaults
+
+	var homeServers []client.HomeServer
+	for _, t := range c.Targets {
+		m, _ := c.module(t.Module)
+		opts := t.Settings.apply(m.Settings.apply(defaults))
+		if m.Type == TypeStatus {
+			statusAddr, statusOpts = t.Address, opts
+			continue
+		}
+
+		// home servers only have secrets of their own, the others share those of the status server
+		opts.Secrets = t.Secrets
+		if len(opts.Secrets) == 0 {
+			opts.Secrets = m.Secrets
+		}
+		hs := client.HomeServer{Address: t.Address, Direct: m.Direct, Options: opts}
+		if m.Type == TypeAuth || m.Type == TypeAcct {
+			hs.Type = m.Type
+		}
+		homeServers = append(homeServers, hs)
+	}
+	return statusAddr, statusOpts, homeServers
+}
+
+// apply returns opts overridden with the settings given.
+func (s Settings) apply(opts client.Options) client.Options {
+	if s.Timeout != nil {
+		opts.Timeout = *s.Timeout
+	}
+	if s.Retries != nil {
+		opts.Retry.Count = *s.Retries
+	}
+	if s.RetryInterval != nil {
+		opts.Retry.Interval = *s.RetryInterval
+	}
+	if s.RetryBackoff != nil {
+		opts.Retry.Backoff = *s.RetryBackoff
+	}
+	if len(s.Secrets) > 0 {
+		opts.Secrets = s.Secrets
+	}
+	return opts
+}
+
+// FlagParser parses the flags of a config file for ff, the top-level keys other than the sections.
+// As JSON is YAML, it reads the flat JSON config files too. The errors are reported with their line numbers.
+func FlagParser(r io.Reader, set func(name, value string) error) error {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if slices.Contains(sections, key.Value) {
+			continue
+		}
+		values := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			values = value.Content
+		}
+		for _, v := range values {
+			if v.Kind != yaml.ScalarNode {
+				return lineError(v, "flag '%v' expects a value", key.Value)
+			}
+			if err := set(key.Value, v.Value); err != nil {
+				return lineError(key, "%v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkSettings returns an error for each setting given out of range, with the line of its value. The ranges
+// are those of the settings of the home servers given as flags.
+func checkSettings(node *yaml.Node, s Settings, owner string) []error {
+	var errs []error
+	if s.Timeout != nil && *s.Timeout <= 0 {
+		errs = append(errs, lineError(valueNode(node, "timeout"), "invalid timeout of %v: '%v', expected a positive duration", owner, *s.Timeout))
+	}
+	if s.Retries != nil && *s.Retries < 0 {
+		errs = append(errs, lineError(valueNode(node, "retries"), "invalid retries of %v: '%v', expected at least 0", owner, *s.Retries))
+	}
+	if s.RetryInterval != nil && *s.RetryInterval < 0 {
+		errs = append(errs, lineError(valueNode(node, "retry_interval"), "invalid retry_interval of %v: '%v', expected at least 0", owner, *s.RetryInterval))
+	}
+	if s.RetryBackoff != nil && *s.RetryBackoff < 1 {
+		errs = append(errs, lineError(valueNode(node, "retry_backoff"), "invalid retry_backoff of %v: '%v', expected at least 1", owner, *s.RetryBackoff))
+	}
+	return errs
+}
+
+// valueNode returns the value of key in the mapping node, or the node itself when it is not found.
+func valueNode(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return node
+}
+
+// checkKeys returns an error for each key of the mapping node that is not allowed.
+func checkKeys(node *yaml.Node, allowed ...string) []error {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	var errs []error
+	for i := 0; i < len(node.Content); i += 2 {
+		if key := node.Content[i]; !slices.Contains(allowed, key.Value) {
+			sorted := slices.Clone(allowed)
+			slices.Sort(sorted)
+			errs = append(errs, lineError(key, "unknown field '%v', expected one of %v", key.Value, strings.Join(sorted, ", ")))
+		}
+	}
+	return errs
+}
+
+// appendDecodeError appends the errors of a yaml.TypeError one by one, they already hold their line numbers.
+func appendDecodeError(errs []error, err error) []error {
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		for _, e := range typeErr.Errors {
+			errs = append(errs, errors.New(e))
+		}
+		return errs
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func lineError(node *yaml.Node, format string, args ...any) error {
+	return fmt.Errorf("line %d: %v", node.Line, fmt.Sprintf(format, args...))
+}
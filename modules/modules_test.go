@@ -0,0 +1,152 @@
+package modules
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bvantagelimited/freeradius_exporter/client"
+)
+
+func TestApply(t *testing.T) {
+	cfg, errs := Parse([]byte(`
+defaults:
+  timeout: 2s
+modules:
+  auth_probe:
+    type: auth
+    retries: 2
+  direct:
+    type: home_server
+    direct: true
+    secrets: [homesecret]
+targets:
+  - address: 127.0.0.1:18121
+    module: status
+    secrets: [statussecret]
+  - address: 172.28.1.2:1812
+    module: auth_probe
+    timeout: 1s
+  - address: 172.28.1.4:18121
+    module: direct
+`))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	flags := client.Options{Secrets: []string{"adminsecret"}, Timeout: 5 * time.Second, Retry: client.RetryPolicy{Backoff: 2}}
+	defaults := cfg.DefaultOptions(flags)
+	addr, statusOpts, homeServers := cfg.Apply("127.0.0.1:1", defaults)
+
+	if addr != "127.0.0.1:18121" {
+		t.Errorf("expected status server 127.0.0.1:18121, got %v", addr)
+	}
+	expectedStatus := client.Options{Secrets: []string{"statussecret"}, Timeout: 2 * time.Second, Retry: client.RetryPolicy{Backoff: 2}}
+	if !reflect.DeepEqual(statusOpts, expectedStatus) {
+		t.Errorf("expected status options %+v, got %+v", expectedStatus, statusOpts)
+	}
+
+	expected := []client.HomeServer{
+		{Address: "172.28.1.2:1812", Type: "auth", Options: client.Options{
+			Timeout: time.Second, Retry: client.RetryPolicy{Count: 2, Backoff: 2},
+		}},
+		{Address: "172.28.1.4:18121", Direct: true, Options: client.Options{
+			Secrets: []string{"homesecret"}, Timeout: 2 * time.Second, Retry: client.RetryPolicy{Backoff: 2},
+		}},
+	}
+	if !reflect.DeepEqual(homeServers, expected) {
+		t.Errorf("expected home servers %+v, got %+v", expected, homeServers)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	_, errs := Parse([]byte(`
+defaults:
+  timeout: soon
+modules:
+  probe:
+    type: proxy
+    weight: 2
+targets:
+  - address: 172.28.1.2
+    module: probe
+  - address: 172.28.1.3:1812
+    module: missing
+  - address: 172.28.1.4:1812
+    secrets: [homesecret]
+`))
+
+	expected := []string{
+		"line 3:",
+		"line 7: unknown field 'weight'",
+		"line 6: module 'probe' has unknown type 'proxy'",
+		"line 9: invalid address of target '172.28.1.2'",
+		"line 11: unknown module 'missing'",
+		"line 13: target '172.28.1.4:1812' is queried through the status server",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for i, prefix := range expected {
+		if !strings.HasPrefix(errs[i].Error(), prefix) {
+			t.Errorf("expected error starting with %q, got %q", prefix, errs[i])
+		}
+	}
+}
+
+func TestParseSettingsRanges(t *testing.T) {
+	_, errs := Parse([]byte(`
+defaults:
+  timeout: 0s
+  retry_backoff: 1
+modules:
+  probe:
+    type: home_server
+    direct: true
+    retries: -1
+    retry_backoff: 0.5
+targets:
+  - address: 172.28.1.2:1812
+    module: probe
+    timeout: -1s
+    retry_interval: -1s
+`))
+
+	expected := []string{
+		"line 3: invalid timeout of defaults: '0s'",
+		"line 9: invalid retries of module 'probe': '-1'",
+		"line 10: invalid retry_backoff of module 'probe': '0.5'",
+		"line 14: invalid timeout of target '172.28.1.2:1812': '-1s'",
+		"line 15: invalid retry_interval of target '172.28.1.2:1812': '-1s'",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for i, prefix := range expected {
+		if !strings.HasPrefix(errs[i].Error(), prefix) {
+			t.Errorf("expected error starting with %q, got %q", prefix, errs[i])
+		}
+	}
+}
+
+func TestFlagParser(t *testing.T) {
+	flags := map[string]string{}
+	set := func(name, value string) error {
+		flags[name] = value
+		return nil
+	}
+
+	// the flat JSON config files are still read
+	if err := FlagParser(strings.NewReader(`{"radius.address": "127.0.0.1:18121", "radius.timeout": 10000}`), set); err != nil {
+		t.Fatal(err)
+	}
+	if err := FlagParser(strings.NewReader("radius.secret: secret\ntargets: []\n"), set); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"radius.address": "127.0.0.1:18121", "radius.timeout": "10000", "radius.secret": "secret"}
+	if !reflect.DeepEqual(flags, expected) {
+		t.Errorf("expected %v, got %v", expected, flags)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/bvantagelimited/freeradius_exporter/client"
+	"github.com/bvantagelimited/freeradius_exporter/collector"
+	"github.com/bvantagelimited/freeradius_exporter/freeradius"
+)
+
+// Output formats of the query subcommand.
+const (
+	formatTable      = "table"
+	formatJSON       = "json"
+	formatPrometheus = "prometheus"
+)
+
+// query sends one status request to the status server and the home servers given with args, the same flags,
+// environment variables and config file as the exporter, and prints their statistics to w. It returns the
+// exit code, 1 when a target could not be queried.
+func query(args []string, w io.Writer) int {
+	c := &config{}
+	fs := newFlagSet("freeradius_exporter query", c)
+	format := fs.String("format", formatTable, "Output format: table, json or prometheus.")
+	raw := fs.Bool("raw", false, "Also print the FreeRADIUS attributes of the responses as received.")
+	if err := parseFlags(fs, args); err != nil {
+		fmt.Fprintln(w, err)
+		return 1
+	}
+	if *raw && *format == formatPrometheus {
+		fmt.Fprintln(w, "raw attributes cannot be printed in prometheus format")
+		return 1
+	}
+
+	radiusClient, _, err := newRadiusClient(c)
+	if err != nil {
+		fmt.Fprintln(w, err)
+		return 1
+	}
+
+	switch *format {
+	case formatPrometheus:
+		return printPrometheus(w, radiusClient, c.rawCounters)
+	case formatTable, formatJSON:
+	default:
+		fmt.Fprintf(w, "unknown format '%v', expected table, json or prometheus\n", *format)
+		return 1
+	}
+
+	stats, _ := radiusClient.StatsContext(context.Background())
+	if *format == formatJSON {
+		err = printJSON(w, stats, *raw)
+	} else {
+		err = printTable(w, stats, *raw)
+	}
+	if err != nil {
+		fmt.Fprintln(w, err)
+		return 1
+	}
+	for _, s := range stats {
+		if s.Err != nil {
+			return 1
+		}
+	}
+	return 0
+}
+
+// printPrometheus prints the metrics the exporter would serve for one scrape.
+func printPrometheus(w io.Writer, radiusClient *client.FreeRADIUSClient, rawCounters bool) int {
+	radiusCollector := collector.NewFreeRADIUSCollector(radiusClient)
+	radiusCollector.SetRawCounters(rawCounters)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(radiusCollector)
+	families, err := registry.Gather()
+	if err != nil {
+		fmt.Fprintln(w, err)
+		return 1
+	}
+
+	code := 0
+	for _, mf := range families {
+		if _, err := expfmt.MetricFamilyToText(w, mf); err != nil {
+			fmt.Fprintln(w, err)
+			return 1
+		}
+		if mf.GetName() == "freeradius_last_scrape_error" {
+			for _, m := range mf.GetMetric() {
+				if m.GetGauge().GetValue() != 0 {
+					code = 1
+				}
+			}
+		}
+	}
+	return code
+}
+
+func printJSON(w io.Writer, stats []client.TargetStatistics, raw bool) error {
+	results := make([]targetJSON, 0, len(stats))
+	for _, s := range stats {
+		results = append(results, newTargetJSON(s, raw))
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+func printTable(w io.Writer, stats []client.TargetStatistics, raw bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, s := range stats {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		name := "status server"
+		if i > 0 {
+			name = "home server"
+			if s.Type != "" {
+				name += " " + s.Type
+			}
+		}
+		fmt.Fprintf(tw, "%v (%v) in %v\n", s.Address, name, s.Duration.Round(time.Microsecond))
+		if s.Err != nil {
+			fmt.Fprintf(tw, "  Error\t%v\n", s.Err)
+			continue
+		}
+		printFields(tw, "", reflect.ValueOf(s.Statistics))
+
+		if raw {
+			for _, a := range s.Raw() {
+				fmt.Fprintf(tw, "  %d\t%v\t% x\n", a.Type, freeradius.Names[a.Type], []byte(a.Value))
+			}
+		}
+	}
+	return tw.Flush()
+}
+
+// printFields prints a row for each field of the statistics struct v, named after its path, e.g. Access.Requests.
+func printFields(w io.Writer, prefix string, v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		name, field := prefix+v.Type().Field(i).Name, v.Field(i)
+		switch value := field.Interface().(type) {
+		case time.Time:
+			if value.IsZero() {
+				fmt.Fprintf(w, "  %v\t-\n", name)
+			} else {
+				fmt.Fprintf(w, "  %v\t%v\n", name, value.Format(time.RFC3339))
+			}
+		case string:
+			if value != "" {
+				fmt.Fprintf(w, "  %v\t%v\n", name, value)
+			}
+		default:
+			if field.Kind() == reflect.Struct {
+				printFields(w, name+".", field)
+			} else {
+				fmt.Fprintf(w, "  %v\t%v\n", name, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/bvantagelimited/freeradius_exporter/internal/radiustest"
+)
+
+func TestQuery(t *testing.T) {
+	addr := radiustest.NewServer(t, "adminsecret", radiustest.Accept)
+
+	var out bytes.Buffer
+	if code := query([]string{"-radius.address", addr, "-raw"}, &out); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %v", code, out.String())
+	}
+	for _, expected := range []string{addr + " (status server)", "Access.Requests", "FreeRADIUS-Total-Access-Requests  00 00 00 2a"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected output to contain %q, got:\n%v", expected, out.String())
+		}
+	}
+
+	out.Reset()
+	if code := query([]string{"-radius.address", addr, "-format", "json"}, &out); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %v", code, out.String())
+	}
+	var results []targetJSON
+	if err := json.Unmarshal(out.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Statistics == nil || results[0].Statistics.Access.Requests != 42 {
+		t.Errorf("expected 42 access requests, got %+v", results)
+	}
+
+	out.Reset()
+	if code := query([]string{"-radius.address", addr, "-format", "prometheus"}, &out); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %v", code, out.String())
+	}
+	if expected := `freeradius_total_access_requests{address="` + addr + `"} 42`; !strings.Contains(out.String(), expected) {
+		t.Errorf("expected output to contain %q, got:\n%v", expected, out.String())
+	}
+
+	out.Reset()
+	if code := query([]string{"-radius.address", addr, "-format", "xml"}, &out); code != 1 {
+		t.Errorf("expected exit code 1 for an unknown format, got %d", code)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/bvantagelimited/freeradius_exporter/client"
+	"github.com/bvantagelimited/freeradius_exporter/collector"
+	"github.com/bvantagelimited/freeradius_exporter/secret"
+)
+
+// reloader re-applies the FreeRADIUS settings of the config, keeping the current ones when the config is invalid.
+type reloader struct {
+	ctx       context.Context
+	args      []string
+	collector *collector.FreeRADIUSCollector
+	mutex     sync.Mutex
+	// stops watching the secret file of the current client
+	stopWatch context.CancelFunc
+	// config applied last
+	current *config
+
+	successful  prometheus.Gauge
+	successTime prometheus.Gauge
+}
+
+func newReloader(ctx context.Context, reg prometheus.Registerer, args []string, radiusCollector *collector.FreeRADIUSCollector) *reloader {
+	r := &reloader{
+		ctx:       ctx,
+		args:      args,
+		collector: radiusCollector,
+		stopWatch: func() {},
+		successful: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "freeradius_exporter_config_last_reload_successful",
+			Help: "Boolean gauge of 1 if the last reload of the config succeeded, or 0 if not",
+		}),
+		successTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "freeradius_exporter_config_last_reload_success_timestamp_seconds",
+			Help: "Epoch timestamp of the last successful reload of the config",
+		}),
+	}
+	reg.MustRegister(r.successful, r.successTime)
+	return r
+}
+
+// apply makes the collector use cl, following the changes of its secret file if any.
+func (r *reloader) apply(c *config, cl *client.FreeRADIUSClient, secretFile *secret.File) {
+	r.stopWatch()
+	ctx, cancel := context.WithCancel(r.ctx)
+	r.stopWatch = cancel
+
+	if secretFile != nil {
+		go secretFile.Watch(ctx, secretReloadInterval, func(value string) {
+			if err := cl.SetSecrets(secret.Lines(value)); err != nil {
+				log.Println(err)
+			}
+		})
+	}
+
+	r.current = c
+	r.collector.SetClient(cl)
+	r.collector.SetRawCounters(c.rawCounters)
+	r.successful.Set(1)
+	r.successTime.SetToCurrentTime()
+}
+
+// reload parses the config again and applies its FreeRADIUS settings. The other settings need a restart.
+func (r *reloader) reload() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	c, _, err := parseConfig(r.args)
+	if err != nil {
+		r.successful.Set(0)
+		return fmt.Errorf("failed reloading config: %w", err)
+	}
+	cl, secretFile, err := newRadiusClient(c)
+	if err != nil {
+		r.successful.Set(0)
+		return fmt.Errorf("failed reloading config: %w", err)
+	}
+
+	r.apply(c, cl, secretFile)
+	log.Println("Config reloaded")
+	return nil
+}
+
+// settings returns the settings of the config applied last, with the secrets redacted.
+func (r *reloader) settings() []setting {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.current.settings()
+}
+
+// watchSignals reloads the config on SIGHUP until ctx is done.
+func (r *reloader) watchSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-hup:
+			if err := r.reload(); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// ServeHTTP reloads the config on POST requests.
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.reload(); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("OK\n"))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/bvantagelimited/freeradius_exporter/collector"
+)
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"radius.address": "127.0.0.1:18121"}`)
+
+	cfg, _, err := parseConfig([]string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, secretFile, err := newRadiusClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := newReloader(ctx, prometheus.NewRegistry(), []string{"-config", path}, collector.NewFreeRADIUSCollector(cl))
+	r.apply(cfg, cl, secretFile)
+
+	write(`{"radius.homeservers": "missing-port"}`)
+	if err := r.reload(); err == nil {
+		t.Error("expected an invalid config to be rejected")
+	}
+	if got := testutil.ToFloat64(r.successful); got != 0 {
+		t.Errorf("expected last reload to be unsuccessful, got %v", got)
+	}
+
+	// every exchange would time out at once
+	write(`{"radius.timeout": 0}`)
+	if err := r.reload(); err == nil {
+		t.Error("expected a zero radius.timeout to be rejected")
+	}
+	if got := testutil.ToFloat64(r.successful); got != 0 {
+		t.Errorf("expected last reload to be unsuccessful, got %v", got)
+	}
+
+	write(`{"radius.homeservers": "172.28.1.2:1812:auth"}`)
+	if err := r.reload(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got := testutil.ToFloat64(r.successful); got != 1 {
+		t.Errorf("expected last reload to be successful, got %v", got)
+	}
+}
@@ -0,0 +1,132 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File is a secret read from a file and re-read when the file changes.
+type File struct {
+	path    string
+	mutex   sync.Mutex
+	value   string
+	modTime time.Time
+	size    int64
+}
+
+// Lookup returns the path of a secret file: file when set, otherwise the systemd credential
+// of the given name in $CREDENTIALS_DIRECTORY when it exists, or an empty string.
+func Lookup(file, credential string) string {
+	if file != "" {
+		return file
+	}
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return ""
+	}
+	path := filepath.Join(dir, credential)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// Lines splits a secret file holding one secret per line, ignoring empty lines.
+func Lines(value string) []string {
+	var lines []string
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// NewFile creates a File and reads the secret.
+func NewFile(path string) (*File, error) {
+	f := &File{path: path}
+	if _, _, err := f.read(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Path returns the path of the file.
+func (f *File) Path() string {
+	return f.path
+}
+
+// Value returns the secret, re-reading the file if it changed since the last read.
+// The last value is kept when the file cannot be read.
+func (f *File) Value() string {
+	value, _, err := f.read()
+	if err != nil {
+		log.Println(err)
+	}
+	return value
+}
+
+// Watch checks the file for changes every interval until ctx is done, and calls onChange with the new secret.
+// Read failures keep the last value.
+func (f *File) Watch(ctx context.Context, interval time.Duration, onChange func(string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// a failure is logged once until the file can be read again
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		value, changed, err := f.read()
+		if err != nil {
+			if !failing {
+				log.Println(err)
+			}
+			failing = true
+			continue
+		}
+		if failing {
+			log.Printf("secret file '%v' can be read again", f.path)
+		}
+		failing = false
+		if changed {
+			log.Printf("secret file '%v' changed, reloaded", f.path)
+			onChange(value)
+		}
+	}
+}
+
+func (f *File) read() (string, bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return f.value, false, fmt.Errorf("failed reading secret file: %w", err)
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.value, false, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return f.value, false, fmt.Errorf("failed reading secret file: %w", err)
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return f.value, false, fmt.Errorf("secret file '%v' is empty", f.path)
+	}
+
+	changed := value != f.value
+	f.value, f.modTime, f.size = value, info.ModTime(), info.Size()
+	return value, changed, nil
+}
@@ -0,0 +1,58 @@
+package secret
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := f.Value(); got != "first" {
+		t.Errorf("expected 'first', got '%v'", got)
+	}
+
+	if err := os.WriteFile(path, []byte("second\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Value(); got != "second" {
+		t.Errorf("expected 'second', got '%v'", got)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Value(); got != "second" {
+		t.Errorf("expected last value 'second' to be kept, got '%v'", got)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "radius-secret"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+
+	if got := Lookup("/etc/secret", "radius-secret"); got != "/etc/secret" {
+		t.Errorf("expected file flag to take precedence, got '%v'", got)
+	}
+	if got := Lookup("", "radius-secret"); got != filepath.Join(dir, "radius-secret") {
+		t.Errorf("expected credential path, got '%v'", got)
+	}
+	if got := Lookup("", "web-auth-token"); got != "" {
+		t.Errorf("expected no path for missing credential, got '%v'", got)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/bvantagelimited/freeradius_exporter/client"
+	"github.com/bvantagelimited/freeradius_exporter/collector"
+)
+
+// topColumns are the columns of the home server table of top, sorted with the keys 1 to 7.
+var topColumns = []struct {
+	name string
+	less func(a, b topRow) bool
+}{
+	{"HOME SERVER", func(a, b topRow) bool { return a.address < b.address }},
+	{"TYPE", func(a, b topRow) bool { return a.typ < b.typ }},
+	{"STATE", func(a, b topRow) bool { return a.state < b.state }},
+	{"OUTSTANDING", func(a, b topRow) bool { return a.outstanding < b.outstanding }},
+	{"EMA 1 (ms)", func(a, b topRow) bool { return a.ema1 < b.ema1 }},
+	{"EMA 10 (ms)", func(a, b topRow) bool { return a.ema10 < b.ema10 }},
+	{"REQ/S", func(a, b topRow) bool { return a.rate < b.rate }},
+}
+
+// topRow is a home server of the table of top.
+type topRow struct {
+	address     string
+	typ         string
+	state       string
+	outstanding uint32
+	ema1, ema10 float64
+	// rate of access and accounting requests, -1 when not known yet
+	rate float64
+}
+
+// topView holds the statistics shown by top, along with the previous ones the rates are computed from.
+type topView struct {
+	stats      []client.TargetStatistics
+	previous   map[string]client.TargetStatistics
+	time       time.Time
+	elapsed    time.Duration
+	sortColumn int
+	descending bool
+}
+
+// top shows the statistics of the status server and the home servers given with args, the same flags,
+// environment variables and config file as the exporter, in the terminal w until q is pressed.
+func top(args []string, w io.Writer) int {
+	c := &config{}
+	fs := newFlagSet("freeradius_exporter top", c)
+	interval := fs.Int("interval", 1000, "Interval, in milliseconds, to fetch statistics.")
+	if err := parseFlags(fs, args); err != nil {
+		fmt.Fprintln(w, err)
+		return 1
+	}
+	if *interval <= 0 {
+		fmt.Fprintf(w, "interval: must be positive, got %v\n", *interval)
+		return 1
+	}
+	radiusClient, _, err := newRadiusClient(c)
+	if err != nil {
+		fmt.Fprintln(w, err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	// keys are read one at a time when stdin is a terminal, which then needs \r\n line endings
+	keys := make(chan byte)
+	newline := "\n"
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			fmt.Fprintln(w, err)
+			return 1
+		}
+		defer term.Restore(fd, state)
+		newline = "\r\n"
+		go func() {
+			buf := make([]byte, 1)
+			for {
+				if _, err := os.Stdin.Read(buf); err != nil {
+					return
+				}
+				keys <- buf[0]
+			}
+		}()
+	}
+
+	// the alternate screen keeps the terminal as it was once top quits
+	fmt.Fprint(w, "\x1b[?1049h")
+	defer fmt.Fprint(w, "\x1b[?1049l")
+
+	view := &topView{}
+	draw := func() {
+		var screen strings.Builder
+		view.render(&screen, c.radiusAddr)
+		fmt.Fprint(w, "\x1b[H\x1b[2J"+strings.ReplaceAll(screen.String(), "\n", newline))
+	}
+	fetch := func(ctx context.Context) []client.TargetStatistics {
+		stats, _ := radiusClient.StatsContext(ctx)
+		return stats
+	}
+	view.run(ctx, fetch, keys, time.Duration(*interval)*time.Millisecond, draw)
+	return 0
+}
+
+// run shows the statistics returned by fetch every interval, and handles the keys, until q is pressed or ctx
+// is done. The statistics are fetched in the background, so that the keys are handled while the servers are
+// slow to answer, and an interval is skipped while the previous fetch is not done.
+func (v *topView) run(ctx context.Context, fetch func(ctx context.Context) []client.TargetStatistics, keys <-chan byte, interval time.Duration, draw func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan []client.TargetStatistics, 1)
+	fetching := false
+	poll := func() {
+		if fetching {
+			return
+		}
+		fetching = true
+		go func() {
+			results <- fetch(ctx)
+		}()
+	}
+
+	draw()
+	poll()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			poll()
+		case stats := <-results:
+			fetching = false
+			v.update(stats, time.Now())
+			draw()
+		case k := <-keys:
+			if !v.key(k) {
+				return
+			}
+			draw()
+		}
+	}
+}
+
+// update replaces the statistics shown, keeping the current ones to compute the rates.
+func (v *topView) update(stats []client.TargetStatistics, now time.Time) {
+	if v.stats != nil {
+		v.previous = make(map[string]client.TargetStatistics)
+		for _, s := range v.stats {
+			if s.Err == nil {
+				v.previous[s.Address] = s
+			}
+		}
+		v.elapsed = now.Sub(v.time)
+	}
+	v.stats, v.time = stats, now
+}
+
+// key handles a key press, returning false to quit. The keys 1 to 7 sort the home servers by a column,
+// pressing the key of the sorted column again reverses the order.
+func (v *topView) key(k byte) bool {
+	switch {
+	case k == 'q' || k == 3: // Ctrl-C in raw mode
+		return false
+	case k >= '1' && int(k-'1') < len(topColumns):
+		column := int(k - '1')
+		v.descending = column == v.sortColumn && !v.descending
+		v.sortColumn = column
+	}
+	return true
+}
+
+// rate returns the per-second rate of a counter of the target, and whether it is known. The rate is not
+// known on the first poll and after the server restarted.
+func (v *topView) rate(t client.TargetStatistics, value func(s *client.Statistics) uint32) (float64, bool) {
+	prev, ok := v.previous[t.Address]
+	if !ok {
+		return 0, false
+	}
+	return rate(t, prev, v.elapsed, value)
+}
+
+// rate returns the per-second rate of a counter between the statistics prev of a target and its statistics t
+// fetched elapsed later, and whether it is known. It is not known when the server restarted meanwhile.
+func rate(t, prev client.TargetStatistics, elapsed time.Duration, value func(s *client.Statistics) uint32) (float64, bool) {
+	if t.Err != nil || prev.Err != nil || elapsed <= 0 || !prev.Statistics.Server.StartTime.Equal(t.Statistics.Server.StartTime) {
+		return 0, false
+	}
+	// the subtraction of the 32-bit values accounts for a wrap between two fetches
+	return float64(value(&t.Statistics)-value(&prev.Statistics)) / elapsed.Seconds(), true
+}
+
+func (v *topView) formatRate(t client.TargetStatistics, value func(s *client.Statistics) uint32) string {
+	if r, ok := v.rate(t, value); ok {
+		return fmt.Sprintf("%.1f", r)
+	}
+	return "-"
+}
+
+func (v *topView) render(w io.Writer, address string) {
+	if len(v.stats) == 0 {
+		fmt.Fprintf(w, "freeradius_exporter top - %v - fetching...    1-%d: sort, q: quit\n\n", address, len(topColumns))
+		return
+	}
+	fmt.Fprintf(w, "freeradius_exporter top - %v - %v    1-%d: sort, q: quit\n\n", address, v.time.Format(time.TimeOnly), len(topColumns))
+
+	main := v.stats[0]
+	if main.Err != nil {
+		fmt.Fprintf(w, "%v\n", main.Err)
+	} else {
+		server := main.Statistics.Server
+		if !server.StartTime.IsZero() {
+			fmt.Fprintf(w, "Up %v since %v", v.time.Sub(server.StartTime).Round(time.Second), server.StartTime.Format(time.DateTime))
+			if !server.HUPTime.IsZero() && !server.HUPTime.Equal(server.StartTime) {
+				fmt.Fprintf(w, ", HUP at %v", server.HUPTime.Format(time.DateTime))
+			}
+			fmt.Fprintln(w)
+		}
+		if main.Statistics.Error != "" {
+			fmt.Fprintf(w, "Stats error: %v\n", main.Statistics.Error)
+		}
+		fmt.Fprintln(w)
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "\tREQUESTS/S\tACCEPTS/S\tREJECTS/S\t")
+		fmt.Fprintf(tw, "Access\t%v\t%v\t%v\t\n",
+			v.formatRate(main, func(s *client.Statistics) uint32 { return s.Access.Requests }),
+			v.formatRate(main, func(s *client.Statistics) uint32 { return s.Access.Accepts }),
+			v.formatRate(main, func(s *client.Statistics) uint32 { return s.Access.Rejects }))
+		fmt.Fprintf(tw, "Accounting\t%v\t\t\t\n",
+			v.formatRate(main, func(s *client.Statistics) uint32 { return s.Accounting.Requests }))
+		tw.Flush()
+
+		q := main.Statistics.Internal
+		fmt.Fprintf(w, "\nQueues: internal %d, proxy %d, auth %d, acct %d, detail %d, %d%% used\n",
+			q.QueueLenInternal, q.QueueLenProxy, q.QueueLenAuth, q.QueueLenAcct, q.QueueLenDetail, server.QueueUsePercentage)
+		fmt.Fprintf(w, "Packets/s: in %d, out %d\n", server.QueuePPSIn, server.QueuePPSOut)
+	}
+
+	if len(v.stats) == 1 {
+		return
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, column := range topColumns {
+		name := column.name
+		if i == v.sortColumn && v.descending {
+			name += " ▼"
+		} else if i == v.sortColumn {
+			name += " ▲"
+		}
+		fmt.Fprintf(tw, "%v\t", name)
+	}
+	fmt.Fprintln(tw)
+	for _, r := range v.rows() {
+		rate := "-"
+		if r.rate >= 0 {
+			rate = fmt.Sprintf("%.1f", r.rate)
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%d\t%.3f\t%.3f\t%v\t\n", r.address, r.typ, r.state, r.outstanding, r.ema1, r.ema10, rate)
+	}
+	tw.Flush()
+}
+
+// rows returns the home servers, sorted by the selected column.
+func (v *topView) rows() []topRow {
+	var rows []topRow
+	for _, t := range v.stats[1:] {
+		r := topRow{address: t.Address, typ: t.Type, state: "unreachable", rate: -1}
+		if t.Err == nil {
+			server := t.Statistics.Server
+			r.state = collector.StateName(server.State)
+			r.outstanding = server.OutstandingRequests
+			r.ema1 = float64(server.EmaUsecWindow1) / 1000
+			r.ema10 = float64(server.EmaUsecWindow10) / 1000
+			if rate, ok := v.rate(t, func(s *client.Statistics) uint32 { return s.Access.Requests + s.Accounting.Requests }); ok {
+				r.rate = rate
+			}
+		}
+		rows = append(rows, r)
+	}
+
+	less := topColumns[v.sortColumn].less
+	sort.SliceStable(rows, func(i, j int) bool {
+		if v.descending {
+			return less(rows[j], rows[i])
+		}
+		return less(rows[i], rows[j])
+	})
+	return rows
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bvantagelimited/freeradius_exporter/client"
+)
+
+func TestTopView(t *testing.T) {
+	start := time.Unix(1000, 0)
+	stats := func(accessRequests, homeRequests uint32) []client.TargetStatistics {
+		main := client.TargetStatistics{Address: "127.0.0.1:18121"}
+		main.Statistics.Server.StartTime = start
+		main.Statistics.Access.Requests = accessRequests
+		slow := client.TargetStatistics{Address: "10.0.0.1:1812", Type: "auth"}
+		slow.Statistics.Server.StartTime = start
+		slow.Statistics.Server.EmaUsecWindow1 = 20000
+		slow.Statistics.Access.Requests = homeRequests
+		fast := client.TargetStatistics{Address: "10.0.0.2:1812", Type: "auth"}
+		fast.Statistics.Server.StartTime = start
+		fast.Statistics.Server.State = 2
+		fast.Statistics.Server.EmaUsecWindow1 = 1000
+		return []client.TargetStatistics{main, slow, fast}
+	}
+
+	v := &topView{}
+	now := start.Add(time.Hour)
+	v.update(stats(math.MaxUint32-9, 0), now)
+	if _, ok := v.rate(v.stats[0], func(s *client.Statistics) uint32 { return s.Access.Requests }); ok {
+		t.Error("expected no rate on the first poll")
+	}
+
+	// the counter wraps between the polls
+	v.update(stats(10, 40), now.Add(2*time.Second))
+	if r, ok := v.rate(v.stats[0], func(s *client.Statistics) uint32 { return s.Access.Requests }); !ok || r != 10 {
+		t.Errorf("expected 10 requests/s, got %v, %v", r, ok)
+	}
+
+	var out strings.Builder
+	v.render(&out, "127.0.0.1:18121")
+	for _, expected := range []string{"Up 1h0m2s since", "HOME SERVER ▲", "dead"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected output to contain %q, got:\n%v", expected, out.String())
+		}
+	}
+
+	v.key('5')
+	if rows := v.rows(); rows[0].address != "10.0.0.2:1812" {
+		t.Errorf("expected the fastest home server first, got %+v", rows)
+	}
+	v.key('5')
+	if rows := v.rows(); rows[0].address != "10.0.0.1:1812" || rows[0].rate != 20 {
+		t.Errorf("expected the slowest home server first with 20 requests/s, got %+v", rows)
+	}
+	if v.key('q') {
+		t.Error("expected q to quit")
+	}
+}
+
+func TestTopRunSlowFetch(t *testing.T) {
+	// the statistics are never fetched, as with a status server that does not answer
+	var fetches atomic.Int32
+	fetch := func(ctx context.Context) []client.TargetStatistics {
+		fetches.Add(1)
+		<-ctx.Done()
+		return nil
+	}
+
+	keys := make(chan byte)
+	done := make(chan struct{})
+	v := &topView{}
+	go func() {
+		v.run(context.Background(), fetch, keys, 10*time.Millisecond, func() {})
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case keys <- '2':
+	case <-time.After(time.Second):
+		t.Fatal("expected keys to be handled while fetching")
+	}
+	keys <- 'q'
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected q to quit while fetching")
+	}
+
+	if v.sortColumn != 1 {
+		t.Errorf("expected the table to be sorted by the second column, got %d", v.sortColumn)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected no fetch while the previous one is not done, got %d fetches", n)
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/bvantagelimited/freeradius_exporter/access"
+	"github.com/bvantagelimited/freeradius_exporter/client"
+	"github.com/bvantagelimited/freeradius_exporter/collector"
+	"github.com/bvantagelimited/freeradius_exporter/events"
+	"github.com/bvantagelimited/freeradius_exporter/freeradius"
+)
+
+// httpMetrics instruments the exporter's own HTTP handlers.
+type httpMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func newHTTPMetrics(reg prometheus.Registerer) *httpMetrics {
+	m := &httpMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "freeradius_exporter_http_requests_total",
+			Help: "Total HTTP requests by path and status code",
+		}, []string{"path", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "freeradius_exporter_http_request_duration_seconds",
+			Help:    "Latency of HTTP requests by path",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"path"}),
+	}
+	reg.MustRegister(m.requests, m.duration)
+	return m
+}
+
+// instrument wraps next with the request counter and latency histogram of path.
+func (m *httpMetrics) instrument(path string, next http.Handler) http.Handler {
+	labels := prometheus.Labels{"path": path}
+	return promhttp.InstrumentHandlerCounter(m.requests.MustCurryWith(labels),
+		promhttp.InstrumentHandlerDuration(m.duration.MustCurryWith(labels), next))
+}
+
+// scrapeHandler serves the metrics of registry and of the FreeRADIUS collector. The collector has until the
+// timeout of the X-Prometheus-Scrape-Timeout-Seconds header minus offset, and then sends what it got by then.
+func scrapeHandler(registry *prometheus.Registry, radiusCollector *collector.FreeRADIUSCollector, offset time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+			if seconds, err := strconv.ParseFloat(v, 64); err == nil && seconds > 0 {
+				timeout := time.Duration(seconds * float64(time.Second))
+				if timeout > offset {
+					timeout -= offset
+				}
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+		}
+
+		scrapeRegistry := prometheus.NewRegistry()
+		scrapeRegistry.MustRegister(radiusCollector.WithContext(ctx))
+		promhttp.HandlerFor(prometheus.Gatherers{registry, scrapeRegistry}, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+var landingTemplate = template.Must(template.New("landing").Funcs(template.FuncMap{
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format("2006-01-02 15:04:05 MST")
+	},
+}).Parse(`<html>
+	<head>
+	<title>FreeRADIUS Exporter</title>
+	<style>
+	body { font-family: sans-serif; }
+	table { border-collapse: collapse; }
+	th, td { padding: 2px 8px; text-align: left; border-bottom: 1px solid #ddd; }
+	.alive { background: #c8e6c9; }
+	.zombie { background: #ffe0b2; }
+	.dead, .unreachable { background: #ffcdd2; }
+	.idle { background: #e0e0e0; }
+	</style>
+	</head>
+	<body>
+	<h1>FreeRADIUS Exporter</h1>
+	<p><a href='{{.MetricsPath}}'>Metrics</a></p>
+	{{- if .ShowStatus}}
+	<h2>Status</h2>
+	<p><a href='/api/v1/stats'>JSON</a></p>
+	{{- with .Server}}
+	<table>
+	<tr><th>Fetched</th><td>{{time $.Fetched}}</td></tr>
+	<tr><th>Status server</th><td>{{.Address}}</td></tr>
+	{{- if .Error}}
+	<tr class='unreachable'><th>Error</th><td>{{.Error}}</td></tr>
+	{{- else}}
+	{{- if .StatsError}}
+	<tr class='dead'><th>Stats error</th><td>{{.StatsError}}</td></tr>
+	{{- end}}
+	<tr><th>Uptime</th><td>{{if .StartTime.IsZero}}-{{else}}{{.Uptime}} since {{time .StartTime}}{{end}}</td></tr>
+	<tr><th>Last HUP</th><td>{{time .HUPTime}}</td></tr>
+	{{- range .Rates}}
+	<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+	{{- end}}
+	<tr><th>Queue usage</th><td>{{.QueueUse}}%</td></tr>
+	<tr><th>Queue lengths</th><td>internal {{.Queues.QueueLenInternal}}, proxy {{.Queues.QueueLenProxy}}, auth {{.Queues.QueueLenAuth}}, acct {{.Queues.QueueLenAcct}}, detail {{.Queues.QueueLenDetail}}</td></tr>
+	<tr><th>Packets/s</th><td>in {{.PPSIn}}, out {{.PPSOut}}</td></tr>
+	{{- end}}
+	</table>
+	{{- else}}
+	<p>No statistics fetched yet.</p>
+	{{- end}}
+	{{- if .HomeServers}}
+	<h2>Home servers</h2>
+	<table>
+	<tr><th>Address</th><th>Type</th><th>State</th><th>Outstanding</th><th>EMA 1 (ms)</th><th>Requests/s</th><th>Time of death</th><th>Time of life</th></tr>
+	{{- range .HomeServers}}
+	{{- if .Error}}
+	<tr class='unreachable'><td>{{.Address}}</td><td>{{.Type}}</td><td>unreachable</td><td colspan='5'>{{.Error}}</td></tr>
+	{{- else}}
+	<tr class='{{.State}}'><td>{{.Address}}</td><td>{{.Type}}</td><td>{{.State}}</td><td>{{.Outstanding}}</td><td>{{printf "%.3f" .EMA1}}</td><td>{{.Rate}}</td><td>{{time .TimeOfDeath}}</td><td>{{time .TimeOfLife}}</td></tr>
+	{{- end}}
+	{{- end}}
+	</table>
+	{{- end}}
+	{{- end}}
+	{{- if .ShowEvents}}
+	<h2>Events</h2>
+	<p><a href='/api/v1/events'>JSON</a></p>
+	<table>
+	<tr><th>Time</th><th>Kind</th><th>Address</th><th>Message</th></tr>
+	{{- range .Events}}
+	<tr><td>{{time .Time}}</td><td>{{.Kind}}</td><td>{{.Address}}</td><td>{{.Message}}</td></tr>
+	{{- end}}
+	</table>
+	{{- end}}
+	{{- if .ShowStatus}}
+	<h2>Configuration</h2>
+	<table>
+	{{- range .Settings}}
+	<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+	{{- end}}
+	</table>
+	{{- end}}
+	</body>
+	</html>`))
+
+// landingPage links to the metrics, and shows the last statistics fetched, the events, newest first, and the
+// effective config to the clients the access policy allows to read them from the API.
+func landingPage(metricsPath string, policy *access.Policy, radiusCollector *collector.FreeRADIUSCollector, eventLog *events.Log, settings func() []setting) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := statusPage{MetricsPath: metricsPath}
+
+		if allowed(policy, r, "/api/v1/stats") {
+			data.ShowStatus = true
+			data.setStats(radiusCollector.Snapshots())
+			data.Settings = settings()
+		}
+		if allowed(policy, r, "/api/v1/events") {
+			data.ShowEvents = true
+			all := eventLog.Events()
+			for i := len(all) - 1; i >= 0; i-- {
+				data.Events = append(data.Events, all[i])
+			}
+		}
+
+		if err := landingTemplate.Execute(w, data); err != nil {
+			log.Println(err)
+		}
+	})
+}
+
+// allowed returns whether the access policy allows the client of r on path.
+func allowed(policy *access.Policy, r *http.Request, path string) bool {
+	clone := r.Clone(r.Context())
+	clone.URL.Path = path
+	_, ok := policy.Check(clone)
+	return ok
+}
+
+// statusPage is the data of the landing page.
+type statusPage struct {
+	MetricsPath string
+	ShowStatus  bool
+	Fetched     time.Time
+	Server      *serverStatus
+	HomeServers []homeServerStatus
+	ShowEvents  bool
+	Events      []events.Event
+	Settings    []setting
+}
+
+// serverStatus is the status server as shown on the landing page.
+type serverStatus struct {
+	Address            string
+	Error              string
+	StatsError         string
+	StartTime, HUPTime time.Time
+	Uptime             time.Duration
+	Rates              []statusRate
+	QueueUse           uint32
+	Queues             client.Internal
+	PPSIn, PPSOut      uint32
+}
+
+// statusRate is a named rate of the status server, "-" when not known yet.
+type statusRate struct {
+	Name  string
+	Value string
+}
+
+// homeServerStatus is a home server as shown on the landing page.
+type homeServerStatus struct {
+	Address                 string
+	Type                    string
+	Error                   string
+	State                   string
+	Outstanding             uint32
+	EMA1                    float64
+	Rate                    string
+	TimeOfDeath, TimeOfLife time.Time
+}
+
+// setStats sets the status of the targets from the last statistics fetched, with the rates computed from
+// the previous ones.
+func (p *statusPage) setStats(last, previous collector.Snapshot) {
+	if last.Time.IsZero() || len(last.Targets) == 0 {
+		return
+	}
+	p.Fetched = last.Time
+	elapsed := last.Time.Sub(previous.Time)
+	prev := make(map[string]client.TargetStatistics)
+	for _, t := range previous.Targets {
+		prev[t.Address] = t
+	}
+	formatRate := func(t client.TargetStatistics, value func(s *client.Statistics) uint32) string {
+		if pt, ok := prev[t.Address]; ok {
+			if r, ok := rate(t, pt, elapsed, value); ok {
+				return fmt.Sprintf("%.1f", r)
+			}
+		}
+		return "-"
+	}
+
+	main := last.Targets[0]
+	p.Server = &serverStatus{Address: main.Address}
+	if main.Err != nil {
+		p.Server.Error = main.Err.Error()
+	} else {
+		server := main.Statistics.Server
+		p.Server.StatsError = main.Statistics.Error
+		p.Server.StartTime, p.Server.HUPTime = server.StartTime, server.HUPTime
+		if !server.StartTime.IsZero() {
+			p.Server.Uptime = last.Time.Sub(server.StartTime).Round(time.Second)
+		}
+		p.Server.Rates = []statusRate{
+			{"Access requests/s", formatRate(main, func(s *client.Statistics) uint32 { return s.Access.Requests })},
+			{"Access accepts/s", formatRate(main, func(s *client.Statistics) uint32 { return s.Access.Accepts })},
+			{"Access rejects/s", formatRate(main, func(s *client.Statistics) uint32 { return s.Access.Rejects })},
+			{"Accounting requests/s", formatRate(main, func(s *client.Statistics) uint32 { return s.Accounting.Requests })},
+		}
+		p.Server.QueueUse = server.QueueUsePercentage
+		p.Server.Queues = main.Statistics.Internal
+		p.Server.PPSIn, p.Server.PPSOut = server.QueuePPSIn, server.QueuePPSOut
+	}
+
+	for _, t := range last.Targets[1:] {
+		hs := homeServerStatus{Address: t.Address, Type: t.Type}
+		if t.Err != nil {
+			hs.Error = t.Err.Error()
+		} else {
+			server := t.Statistics.Server
+			hs.State = collector.StateName(server.State)
+			hs.Outstanding = server.OutstandingRequests
+			hs.EMA1 = float64(server.EmaUsecWindow1) / 1000
+			hs.Rate = formatRate(t, func(s *client.Statistics) uint32 { return s.Access.Requests + s.Accounting.Requests })
+			hs.TimeOfDeath, hs.TimeOfLife = server.TimeOfDeath, server.TimeOfLife
+		}
+		p.HomeServers = append(p.HomeServers, hs)
+	}
+}
+
+// targetJSON is the JSON representation of the statistics of a target.
+type targetJSON struct {
+	Address    string             `json:"address"`
+	Type       string             `json:"type,omitempty"`
+	Duration   float64            `json:"duration_seconds"`
+	Error      string             `json:"error,omitempty"`
+	Statistics *client.Statistics `json:"statistics,omitempty"`
+	Raw        []rawAttributeJSON `json:"raw,omitempty"`
+}
+
+type rawAttributeJSON struct {
+	Type  byte   `json:"type"`
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value"`
+}
+
+// newTargetJSON returns the JSON representation of t, with the FreeRADIUS attributes of the response when raw is set.
+func newTargetJSON(t client.TargetStatistics, raw bool) targetJSON {
+	result := targetJSON{Address: t.Address, Type: t.Type, Duration: t.Duration.Seconds()}
+	if t.Err != nil {
+		result.Error = t.Err.Error()
+	} else {
+		result.Statistics = &t.Statistics
+	}
+	if raw {
+		for _, a := range t.Raw() {
+			result.Raw = append(result.Raw, rawAttributeJSON{Type: a.Type, Name: freeradius.Names[a.Type], Value: fmt.Sprintf("%x", []byte(a.Value))})
+		}
+	}
+	return result
+}
+
+// statsHandler serves the statistics of the status server and the home servers fetched last as JSON.
+func statsHandler(radiusCollector *collector.FreeRADIUSCollector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats, fetched := radiusCollector.Stats(r.Context())
+		if fetched.IsZero() {
+			http.Error(w, "No statistics fetched yet", http.StatusServiceUnavailable)
+			return
+		}
+
+		data := struct {
+			Time    time.Time    `json:"time"`
+			Targets []targetJSON `json:"targets"`
+		}{Time: fetched, Targets: make([]targetJSON, 0, len(stats))}
+		for _, s := range stats {
+			data.Targets = append(data.Targets, newTargetJSON(s, false))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+	})
+}
+
+// healthy reports that the exporter is running.
+func healthy(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("OK\n"))
+}
+
+// readyHandler reports whether the exporter is ready, once an exchange with the status server succeeded.
+func readyHandler(radiusCollector *collector.FreeRADIUSCollector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !radiusCollector.Ready(r.Context()) {
+			http.Error(w, "FreeRADIUS not reached yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("OK\n"))
+	})
+}
@@ -0,0 +1,128 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// SignatureHeader is the header holding the HMAC-SHA256 of the body, as "sha256=<hex>".
+const SignatureHeader = "X-FreeRADIUS-Exporter-Signature"
+
+// queueSize is the number of events waiting to be sent before new ones are dropped.
+const queueSize = 100
+
+// Options of a Notifier.
+type Options struct {
+	// Secret signs the events when set.
+	Secret string
+	// Timeout of each POST.
+	Timeout time.Duration
+	// Retries is the number of times an event is sent again after a failure.
+	Retries int
+	// RetryInterval is the time to wait before the first retry, doubled with each retry.
+	RetryInterval time.Duration
+}
+
+// Notifier posts events as JSON to webhook URLs.
+type Notifier struct {
+	urls   []string
+	opts   Options
+	client *http.Client
+	queue  chan []byte
+}
+
+// NewNotifier creates a Notifier.
+func NewNotifier(urls []string, opts Options) *Notifier {
+	return &Notifier{
+		urls:   urls,
+		opts:   opts,
+		client: &http.Client{Timeout: opts.Timeout},
+		queue:  make(chan []byte, queueSize),
+	}
+}
+
+// Notify queues event to be sent to every URL. It does not block, the event is dropped when the queue is full.
+func (n *Notifier) Notify(event any) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("failed encoding webhook event: %v", err)
+		return
+	}
+	select {
+	case n.queue <- body:
+	default:
+		log.Println("webhook queue full, dropped event")
+	}
+}
+
+// Run sends the queued events until ctx is done.
+func (n *Notifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case body := <-n.queue:
+			for _, url := range n.urls {
+				if err := n.send(ctx, url, body); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+	}
+}
+
+// send posts body to url, retrying on failure.
+func (n *Notifier) send(ctx context.Context, url string, body []byte) error {
+	interval := n.opts.RetryInterval
+	var err error
+	for attempt := 0; attempt <= n.opts.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("failed sending webhook to '%v': %w", url, ctx.Err())
+			case <-time.After(interval):
+			}
+			interval *= 2
+		}
+		if err = n.post(ctx, url, body); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed sending webhook to '%v': %w", url, err)
+}
+
+func (n *Notifier) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.opts.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(n.opts.Secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature header value of body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotifier(t *testing.T) {
+	type request struct {
+		body      string
+		signature string
+	}
+	requests := make(chan request, 10)
+	failures := 2
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{body: string(body), signature: r.Header.Get(SignatureHeader)}
+	}))
+	defer receiver.Close()
+
+	n := NewNotifier([]string{receiver.URL}, Options{
+		Secret:        "hooksecret",
+		Timeout:       time.Second,
+		Retries:       2,
+		RetryInterval: time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.Run(ctx)
+
+	n.Notify(map[string]string{"address": "172.28.1.2:1812", "new_state": "dead"})
+
+	select {
+	case r := <-requests:
+		expected := `{"address":"172.28.1.2:1812","new_state":"dead"}`
+		if r.body != expected {
+			t.Errorf("expected body %v, got %v", expected, r.body)
+		}
+		if r.signature != Sign("hooksecret", []byte(expected)) {
+			t.Errorf("invalid signature %v", r.signature)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+}
+
+func TestNotifierGivesUp(t *testing.T) {
+	attempts := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	n := NewNotifier([]string{receiver.URL}, Options{Timeout: time.Second, Retries: 1, RetryInterval: time.Millisecond})
+	if err := n.send(context.Background(), receiver.URL, []byte(`{}`)); err == nil {
+		t.Error("expected an error")
+	}
+	if attempts != 2 {
+		t.Errorf("expected 2 attempts, got %v", attempts)
+	}
+}